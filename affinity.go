@@ -0,0 +1,189 @@
+package cdbpool
+
+import (
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/stn81/bigid"
+)
+
+const (
+	// affinityReplicas is the number of points of a node of weight 1 on
+	// the hash ring.
+	affinityReplicas = 100
+	// defaultAffinityLoadFactor bounds the requests in flight of a node to
+	// 1.25 times the average before its vsids spill to the next node.
+	defaultAffinityLoadFactor = 1.25
+)
+
+type ringPoint struct {
+	hash uint64
+	node *Node
+}
+
+// hashRing is a consistent hash ring over a set of nodes.
+type hashRing struct {
+	nodes   []*Node
+	members map[*Node]bool
+	points  []ringPoint
+}
+
+func newHashRing(nodes []*Node) *hashRing {
+	ring := &hashRing{
+		nodes:   append([]*Node(nil), nodes...),
+		members: make(map[*Node]bool, len(nodes)),
+	}
+	for _, node := range nodes {
+		ring.members[node] = true
+		weight := node.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		for i := 0; i < weight*affinityReplicas; i++ {
+			ring.points = append(ring.points, ringPoint{
+				hash: hashString(node.Addr + "#" + strconv.Itoa(i)),
+				node: node,
+			})
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool {
+		return ring.points[i].hash < ring.points[j].hash
+	})
+	return ring
+}
+
+// has reports whether the ring is built over exactly nodes. Nodes are
+// compared by identity, as UpdateNodes opens a new node when one is
+// re-added or its weight changes.
+func (r *hashRing) has(nodes []*Node) bool {
+	if len(r.nodes) != len(nodes) {
+		return false
+	}
+	for i, node := range nodes {
+		if r.nodes[i] != node {
+			return false
+		}
+	}
+	return true
+}
+
+// missing returns the nodes which are not on the ring.
+func (r *hashRing) missing(nodes []*Node) []*Node {
+	var missing []*Node
+	for _, node := range nodes {
+		if !r.members[node] {
+			missing = append(missing, node)
+		}
+	}
+	return missing
+}
+
+// search returns the index of the first point at or after hash.
+func (r *hashRing) search(hash uint64) int {
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= hash
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return i
+}
+
+type affinityBalancer struct {
+	loadFactor float64
+
+	mu   sync.Mutex
+	ring *hashRing
+}
+
+// NewAffinityBalancer returns a Balancer hashing the vsid of the routed
+// bigId onto the nodes with consistent hashing, so that the traffic of a
+// shard stays on the same node. A node having more than loadFactor times
+// the average requests in flight hands the request to the next node of the
+// ring. loadFactor defaults to 1.25 if not greater than 1.
+//
+// The ring is built over all the nodes of the cluster, and rebuilt only
+// when they change. Unhealthy nodes are skipped as not candidates, so only
+// their vsids move to other nodes until they are re-admitted.
+func NewAffinityBalancer(loadFactor float64) Balancer {
+	if loadFactor <= 1 {
+		loadFactor = defaultAffinityLoadFactor
+	}
+	return &affinityBalancer{loadFactor: loadFactor}
+}
+
+func (b *affinityBalancer) Pick(route *RouteInfo, nodes []*Node) *Node {
+	if route == nil || len(nodes) == 1 {
+		return nodes[0]
+	}
+
+	var (
+		ring  = b.getRing(nodes)
+		total int64
+	)
+
+	for _, node := range nodes {
+		total += node.InFlight()
+	}
+	bound := int64(math.Ceil(b.loadFactor * float64(total+1) / float64(len(nodes))))
+
+	var (
+		start = ring.search(hashString(strconv.FormatUint(bigid.GetVSId(route.BigId), 10)))
+		first *Node
+	)
+	for i := 0; i < len(ring.points); i++ {
+		node := ring.points[(start+i)%len(ring.points)].node
+		if !isCandidate(node, nodes) {
+			continue
+		}
+		if node.InFlight()+1 <= bound {
+			return node
+		}
+		if first == nil {
+			first = node
+		}
+	}
+	return first
+}
+
+// setNodes builds the ring over the nodes of the cluster.
+func (b *affinityBalancer) setNodes(nodes []*Node) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.ring == nil || !b.ring.has(nodes) {
+		b.ring = newHashRing(nodes)
+	}
+}
+
+// getRing returns the ring set by setNodes. Candidates which are not on it,
+// as when the balancer is used outside of a Cluster, are added to it.
+func (b *affinityBalancer) getRing(nodes []*Node) *hashRing {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.ring == nil {
+		b.ring = newHashRing(nodes)
+	} else if missing := b.ring.missing(nodes); len(missing) > 0 {
+		b.ring = newHashRing(append(b.ring.nodes, missing...))
+	}
+	return b.ring
+}
+
+func isCandidate(node *Node, nodes []*Node) bool {
+	for _, candidate := range nodes {
+		if candidate == node {
+			return true
+		}
+	}
+	return false
+}
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
@@ -0,0 +1,154 @@
+package cdbpool
+
+import (
+	"math/rand"
+	"sync/atomic"
+)
+
+// Names of the built-in balancers, as given by the DSN param balancer.
+const (
+	BalancerRandom        = "random"
+	BalancerRoundRobin    = "roundrobin"
+	BalancerWeighted      = "weighted"
+	BalancerLeastInFlight = "leastinflight"
+	BalancerP2C           = "p2c"
+	BalancerAffinity      = "affinity"
+)
+
+// Balancer picks the node serving a request routed to route. The candidate
+// nodes are never empty, and only contain healthy nodes unless none is.
+type Balancer interface {
+	Pick(route *RouteInfo, nodes []*Node) *Node
+}
+
+// nodesSetter is implemented by the balancers keeping state about the nodes
+// of a cluster, which are set whenever they change.
+type nodesSetter interface {
+	setNodes(nodes []*Node)
+}
+
+// newBalancer returns the built-in balancer called name.
+func newBalancer(name string) (Balancer, error) {
+	switch name {
+	case BalancerRandom:
+		return NewRandomBalancer(), nil
+	case BalancerRoundRobin:
+		return NewRoundRobinBalancer(), nil
+	case BalancerWeighted:
+		return NewWeightedBalancer(), nil
+	case BalancerLeastInFlight:
+		return NewLeastInFlightBalancer(), nil
+	case BalancerP2C:
+		return NewP2CBalancer(), nil
+	case BalancerAffinity:
+		return NewAffinityBalancer(0), nil
+	default:
+		return nil, errInvalidDSNBalancer
+	}
+}
+
+type randomBalancer struct{}
+
+// NewRandomBalancer returns a Balancer picking nodes uniformly at random.
+func NewRandomBalancer() Balancer {
+	return randomBalancer{}
+}
+
+func (randomBalancer) Pick(route *RouteInfo, nodes []*Node) *Node {
+	return nodes[rand.Intn(len(nodes))]
+}
+
+type roundRobinBalancer struct {
+	next uint64
+}
+
+// NewRoundRobinBalancer returns a Balancer picking nodes in turn.
+func NewRoundRobinBalancer() Balancer {
+	return &roundRobinBalancer{}
+}
+
+func (b *roundRobinBalancer) Pick(route *RouteInfo, nodes []*Node) *Node {
+	n := atomic.AddUint64(&b.next, 1) - 1
+	return nodes[n%uint64(len(nodes))]
+}
+
+type weightedBalancer struct{}
+
+// NewWeightedBalancer returns a Balancer picking nodes at random in
+// proportion to their weight, given in the DSN address list as addr#weight.
+func NewWeightedBalancer() Balancer {
+	return weightedBalancer{}
+}
+
+func (weightedBalancer) Pick(route *RouteInfo, nodes []*Node) *Node {
+	total := 0
+	for _, node := range nodes {
+		total += node.Weight
+	}
+	if total <= 0 {
+		return nodes[rand.Intn(len(nodes))]
+	}
+
+	r := rand.Intn(total)
+	for _, node := range nodes {
+		if r < node.Weight {
+			return node
+		}
+		r -= node.Weight
+	}
+	return nodes[len(nodes)-1]
+}
+
+type leastInFlightBalancer struct{}
+
+// NewLeastInFlightBalancer returns a Balancer picking the node with the
+// fewest requests in flight. Ties are broken at random.
+func NewLeastInFlightBalancer() Balancer {
+	return leastInFlightBalancer{}
+}
+
+func (leastInFlightBalancer) Pick(route *RouteInfo, nodes []*Node) *Node {
+	var (
+		offset = rand.Intn(len(nodes))
+		best   *Node
+		min    int64
+	)
+
+	for i := range nodes {
+		node := nodes[(offset+i)%len(nodes)]
+		if n := node.InFlight(); best == nil || n < min {
+			best, min = node, n
+		}
+	}
+	return best
+}
+
+type p2cBalancer struct{}
+
+// NewP2CBalancer returns a power of two choices Balancer: it picks two
+// nodes at random and keeps the one with the lowest latency average
+// weighted by its requests in flight.
+func NewP2CBalancer() Balancer {
+	return p2cBalancer{}
+}
+
+func (p2cBalancer) Pick(route *RouteInfo, nodes []*Node) *Node {
+	if len(nodes) == 1 {
+		return nodes[0]
+	}
+
+	i := rand.Intn(len(nodes))
+	j := rand.Intn(len(nodes) - 1)
+	if j >= i {
+		j++
+	}
+
+	if p2cScore(nodes[j]) < p2cScore(nodes[i]) {
+		return nodes[j]
+	}
+	return nodes[i]
+}
+
+func p2cScore(node *Node) float64 {
+	return float64(node.Latency()+1) * float64(node.InFlight()+1)
+}
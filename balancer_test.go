@@ -0,0 +1,175 @@
+package cdbpool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stn81/bigid"
+)
+
+func testNodes(weights ...int) []*Node {
+	nodes := make([]*Node, len(weights))
+	for i, weight := range weights {
+		nodes[i] = &Node{Addr: string(rune('a' + i)), Weight: weight}
+	}
+	return nodes
+}
+
+func pickCounts(b Balancer, nodes []*Node, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		counts[b.Pick(&RouteInfo{}, nodes).Addr]++
+	}
+	return counts
+}
+
+func TestRoundRobinBalancer(t *testing.T) {
+	counts := pickCounts(NewRoundRobinBalancer(), testNodes(1, 1, 1), 30)
+	for addr, n := range counts {
+		if n != 10 {
+			t.Errorf("node %v: picked %d times, want 10", addr, n)
+		}
+	}
+}
+
+func TestWeightedBalancer(t *testing.T) {
+	counts := pickCounts(NewWeightedBalancer(), testNodes(1, 3), 4000)
+	if counts["b"] < 2*counts["a"] || counts["b"] > 4*counts["a"] {
+		t.Errorf("got %v, want b picked about 3 times as often as a", counts)
+	}
+}
+
+func TestLeastInFlightBalancer(t *testing.T) {
+	nodes := testNodes(1, 1, 1)
+	nodes[0].inflight = 3
+	nodes[1].inflight = 1
+	nodes[2].inflight = 2
+
+	counts := pickCounts(NewLeastInFlightBalancer(), nodes, 10)
+	if counts["b"] != 10 {
+		t.Errorf("got %v, want only b", counts)
+	}
+}
+
+func TestP2CBalancer(t *testing.T) {
+	nodes := testNodes(1, 1)
+	nodes[0].observe(50*time.Millisecond, nil)
+	nodes[1].observe(time.Millisecond, nil)
+
+	counts := pickCounts(NewP2CBalancer(), nodes, 10)
+	if counts["b"] != 10 {
+		t.Errorf("got %v, want only b", counts)
+	}
+
+	nodes[1].observe(0, errInvalidNodeWeight)
+	if latency := nodes[1].Latency(); latency < latencyPenalty/10 {
+		t.Errorf("latency after a failed call: got %v, want penalized", latency)
+	}
+}
+
+func TestParseNode(t *testing.T) {
+	tests := []struct {
+		in     string
+		addr   string
+		weight int
+		err    error
+	}{
+		{"127.0.0.1:9123", "127.0.0.1:9123", 1, nil},
+		{"127.0.0.1:9123#5", "127.0.0.1:9123", 5, nil},
+		{"127.0.0.1:9123#0", "", 0, errInvalidNodeWeight},
+		{"127.0.0.1:9123#x", "", 0, errInvalidNodeWeight},
+	}
+
+	for _, test := range tests {
+		node, err := parseNode(test.in)
+		if err != test.err {
+			t.Errorf("parseNode(%q): got err %v, want %v", test.in, err, test.err)
+			continue
+		}
+		if err == nil && (node.Addr != test.addr || node.Weight != test.weight) {
+			t.Errorf("parseNode(%q): got %v#%d, want %v#%d", test.in, node.Addr, node.Weight, test.addr, test.weight)
+		}
+	}
+}
+
+func TestAffinityBalancer(t *testing.T) {
+	var (
+		b     = NewAffinityBalancer(0)
+		nodes = testNodes(1, 1, 1, 1)
+		picks = make(map[uint64]*Node)
+	)
+
+	for vsid := uint64(0); vsid < 200; vsid++ {
+		route := &RouteInfo{BigId: bigid.New(vsid)}
+		node := b.Pick(route, nodes)
+		if again := b.Pick(&RouteInfo{BigId: bigid.New(vsid)}, nodes); again != node {
+			t.Fatalf("vsid %d: picked %v then %v", vsid, node.Addr, again.Addr)
+		}
+		picks[vsid] = node
+	}
+
+	// Ejecting a node only moves its own vsids.
+	for vsid, node := range picks {
+		moved := b.Pick(&RouteInfo{BigId: bigid.New(vsid)}, nodes[1:])
+		if node != nodes[0] && moved != node {
+			t.Errorf("vsid %d: moved from %v to %v", vsid, node.Addr, moved.Addr)
+		}
+	}
+
+	// An overloaded node hands its vsids to the next node.
+	for vsid, node := range picks {
+		node.inflight = 10
+		if spilled := b.Pick(&RouteInfo{BigId: bigid.New(vsid)}, nodes); spilled == node {
+			t.Errorf("vsid %d: picked overloaded node %v", vsid, node.Addr)
+		}
+		node.inflight = 0
+	}
+}
+
+func TestAffinityBalancerRing(t *testing.T) {
+	var (
+		b     = NewAffinityBalancer(0).(*affinityBalancer)
+		nodes = testNodes(1, 1, 1)
+		route = &RouteInfo{BigId: bigid.New(1)}
+	)
+
+	// The ring of the cluster nodes is kept while picking among subsets of
+	// them, as the hedged reads and the health checker do.
+	b.setNodes(nodes)
+	ring := b.ring
+	for _, candidates := range [][]*Node{nodes[1:], nodes[:2], nodes} {
+		if node := b.Pick(route, candidates); !isCandidate(node, candidates) {
+			t.Errorf("picked %v, not a candidate", node.Addr)
+		}
+	}
+	if b.ring != ring {
+		t.Errorf("ring rebuilt for a subset of the nodes")
+	}
+
+	b.setNodes(nodes[:2])
+	if b.ring == ring {
+		t.Errorf("ring not rebuilt for new nodes")
+	}
+}
+
+func TestAffinityBalancerReaddedNode(t *testing.T) {
+	var (
+		b     = NewAffinityBalancer(0)
+		nodes = testNodes(1, 1)
+	)
+
+	for vsid := uint64(0); vsid < 50; vsid++ {
+		b.Pick(&RouteInfo{BigId: bigid.New(vsid)}, nodes)
+	}
+
+	// b is removed then re-added by UpdateNodes, which opens a new node
+	// with the same address.
+	b.Pick(&RouteInfo{BigId: bigid.New(0)}, nodes[:1])
+	readded := []*Node{nodes[0], {Addr: nodes[1].Addr, Weight: 1}}
+
+	for vsid := uint64(0); vsid < 50; vsid++ {
+		if node := b.Pick(&RouteInfo{BigId: bigid.New(vsid)}, readded); node == nodes[1] {
+			t.Fatalf("vsid %d: picked the removed node %v", vsid, node.Addr)
+		}
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 
 	"github.com/stn81/bigid"
-	"github.com/stn81/log"
 	"github.com/stn81/kate/utils"
 )
 
@@ -45,7 +44,7 @@ func (exr *beginExecutor) Run() (tx driver.Tx, err error) {
 	}
 
 	if resp, err = exr.dbc.call(exr.ctx, req); err != nil {
-		log.Error(exr.ctx, "db.transaction.begin", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
+		exr.dbc.logger().Error(exr.ctx, "db.transaction.begin", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
 		return nil, driver.ErrBadConn
 	}
 
@@ -59,7 +58,7 @@ func (exr *beginExecutor) Run() (tx driver.Tx, err error) {
 			}
 		}
 		err = NewDBError(resp.GetError(), resp.GetErrMsg(), sqlInfo)
-		log.Error(exr.ctx, "db.transaction.begin", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
+		exr.dbc.logger().Error(exr.ctx, "db.transaction.begin", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
 		return
 	}
 
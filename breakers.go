@@ -14,13 +14,14 @@ var (
 	breakersLock sync.Mutex
 )
 
-func getBreaker(addr string) *gobreaker.CircuitBreaker {
+// getBreaker returns the circuit breaker shared by the connections to addr.
+// It is created from settings, or from the default settings if nil.
+func getBreaker(addr string, settings *gobreaker.Settings) *gobreaker.CircuitBreaker {
 	breakersLock.Lock()
 	defer breakersLock.Unlock()
 	breaker, ok := breakers[addr]
 	if !ok {
-		breaker = gobreaker.NewCircuitBreaker(gobreaker.Settings{
-			Name:        fmt.Sprint("circuit breaker db-", addr),
+		st := gobreaker.Settings{
 			MaxRequests: 10,
 			Interval:    time.Duration(5 * time.Second),
 			Timeout:     time.Duration(10 * time.Second),
@@ -30,7 +31,14 @@ func getBreaker(addr string) *gobreaker.CircuitBreaker {
 			OnStateChange: func(name string, from, to gobreaker.State) {
 				log.Info(mctx, "circuit breaker state changed", "name", name, "from", from, "to", to)
 			},
-		})
+		}
+		if settings != nil {
+			st = *settings
+		}
+		if st.Name == "" {
+			st.Name = fmt.Sprint("circuit breaker db-", addr)
+		}
+		breaker = gobreaker.NewCircuitBreaker(st)
 		breakers[addr] = breaker
 	}
 	return breaker
@@ -0,0 +1,162 @@
+package cdbpool
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrBulkInsertNoColumns = errors.New("bulk insert: no columns")
+)
+
+// valueListOverhead approximates the protobuf framing of a ValueList
+// and of each of its values.
+const (
+	valueListOverhead = 8
+	valueOverhead     = 4
+)
+
+// BulkInsertChunk is the outcome of a single MulInsertRequest.
+type BulkInsertChunk struct {
+	Rows         int // Number of rows sent in the chunk
+	RowsAffected int64
+	LastInsertId int64
+}
+
+// BulkInsertResult is the outcome of BulkInsert, with one entry per chunk.
+type BulkInsertResult struct {
+	Chunks       []BulkInsertChunk
+	RowsAffected int64
+}
+
+// BulkInsert inserts rows into table with the mulinsert command, routed by
+// the RouteInfo stored in ctx. Rows are split into chunks so that each
+// request stays below the connection's MaxAllowedPacket.
+//
+// Values are encoded like EncodeValue. The mulinsert command has no NULL,
+// so that a nil value fails before anything is sent, with an error naming
+// its row and column and wrapping ErrNullValue.
+//
+// Chunks are not sent in a transaction: on error, the result reports the
+// chunks which were inserted before the failure.
+func BulkInsert(ctx context.Context, db *sql.DB, table string, columns []string, rows [][]interface{}) (result *BulkInsertResult, err error) {
+	route := GetRoute(ctx)
+	if route == nil {
+		return nil, ErrMissingRouteInfo
+	}
+
+	if len(columns) == 0 {
+		return nil, ErrBulkInsertNoColumns
+	}
+
+	if len(rows) == 0 {
+		return &BulkInsertResult{}, nil
+	}
+
+	result = &BulkInsertResult{}
+	err = rawConn(ctx, db, func(dbc *Conn) (err error) {
+		valueLists := make([]*ValueList, len(rows))
+		for i, row := range rows {
+			if valueLists[i], err = bulkValueList(dbc.location(), i, columns, row); err != nil {
+				return err
+			}
+		}
+
+		chunks, err := splitValueLists(dbc.MaxAllowedPacket, table, columns, valueLists)
+		if err != nil {
+			return err
+		}
+
+		for _, chunk := range chunks {
+			exr := &mulInsertExecutor{
+				RouteInfo:  &RouteInfo{DBName: route.DBName, BigId: route.BigId},
+				ctx:        ctx,
+				dbc:        dbc,
+				table:      table,
+				columns:    columns,
+				valueLists: chunk,
+			}
+
+			r, err := exr.Run()
+			if err != nil {
+				return err
+			}
+
+			res := r.(*Result)
+			result.Chunks = append(result.Chunks, BulkInsertChunk{
+				Rows:         len(chunk),
+				RowsAffected: res.rowsAffected,
+				LastInsertId: res.lastInsertId,
+			})
+			result.RowsAffected += res.rowsAffected
+		}
+		return nil
+	})
+	return
+}
+
+// BulkInsert inserts rows into table, routed by the DB's route.
+func (db *DB) BulkInsert(table string, columns []string, rows [][]interface{}) (*BulkInsertResult, error) {
+	return BulkInsert(db.ctx, db.DB, table, columns, rows)
+}
+
+// bulkValueList encodes row, with its times in loc.
+func bulkValueList(loc *time.Location, rowIdx int, columns []string, row []interface{}) (*ValueList, error) {
+	if len(row) != len(columns) {
+		return nil, fmt.Errorf("bulk insert: row %d has %d values, want %d", rowIdx, len(row), len(columns))
+	}
+
+	vl := &ValueList{
+		Values: make([]string, len(row)),
+	}
+	for i, v := range row {
+		s, _, err := encodeValue(v, loc)
+		if err != nil {
+			return nil, fmt.Errorf("bulk insert: row %d column %q: %w", rowIdx, columns[i], err)
+		}
+		vl.Values[i] = s
+	}
+	return vl, nil
+}
+
+// splitValueLists splits valueLists into chunks whose estimated encoded
+// size does not exceed maxAllowedPacket. A non-positive maxAllowedPacket
+// disables splitting.
+func splitValueLists(maxAllowedPacket int, table string, columns []string, valueLists []*ValueList) ([][]*ValueList, error) {
+	if maxAllowedPacket <= 0 || len(valueLists) == 0 {
+		return [][]*ValueList{valueLists}, nil
+	}
+
+	header := len(table) + valueListOverhead
+	for _, column := range columns {
+		header += len(column) + valueOverhead
+	}
+
+	var (
+		chunks [][]*ValueList
+		start  = 0
+		size   = header
+	)
+
+	for i, vl := range valueLists {
+		rowSize := valueListOverhead
+		for _, v := range vl.Values {
+			rowSize += len(v) + valueOverhead
+		}
+
+		if header+rowSize > maxAllowedPacket {
+			return nil, ErrSqlTooLarge
+		}
+
+		if size+rowSize > maxAllowedPacket {
+			chunks = append(chunks, valueLists[start:i])
+			start, size = i, header
+		}
+		size += rowSize
+	}
+	chunks = append(chunks, valueLists[start:])
+	return chunks, nil
+}
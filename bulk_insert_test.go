@@ -0,0 +1,54 @@
+package cdbpool
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSplitValueLists(t *testing.T) {
+	var (
+		columns    = []string{"id", "value"}
+		valueLists = make([]*ValueList, 10)
+	)
+
+	for i := range valueLists {
+		valueLists[i] = &ValueList{
+			Values: []string{fmt.Sprint(i), "0123456789"},
+		}
+	}
+
+	chunks, err := splitValueLists(100, "test", columns, valueLists)
+	if err != nil {
+		t.Fatalf("splitValueLists() error: %v", err)
+	}
+
+	total := 0
+	for _, chunk := range chunks {
+		total += len(chunk)
+	}
+	if len(chunks) < 2 || total != len(valueLists) {
+		t.Errorf("splitValueLists(): got %d chunks with %d rows, want >= 2 chunks with %d rows", len(chunks), total, len(valueLists))
+	}
+
+	if _, err = splitValueLists(20, "test", columns, valueLists); err != ErrSqlTooLarge {
+		t.Errorf("splitValueLists() with oversized row: got %v, want ErrSqlTooLarge", err)
+	}
+
+	if chunks, _ = splitValueLists(0, "test", columns, valueLists); len(chunks) != 1 {
+		t.Errorf("splitValueLists() without limit: got %d chunks, want 1", len(chunks))
+	}
+}
+
+func TestBulkValueListNull(t *testing.T) {
+	columns := []string{"id", "name"}
+
+	_, err := bulkValueList(time.UTC, 3, columns, []interface{}{1, nil})
+	if !errors.Is(err, ErrNullValue) {
+		t.Fatalf("nil value: got err=%v, want %v", err, ErrNullValue)
+	}
+	if want := `bulk insert: row 3 column "name": ` + ErrNullValue.Error(); err.Error() != want {
+		t.Errorf("nil value: got %q, want %q", err, want)
+	}
+}
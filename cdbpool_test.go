@@ -1,16 +1,23 @@
-package cdbpool
+package cdbpool_test
 
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stn81/bigid"
+	"github.com/stn81/cdbpool"
+	"github.com/stn81/cdbpool/cdbpooltest"
 )
 
 var (
+	srv *cdbpooltest.Server
 	db  *sql.DB
 	err error
 )
@@ -18,9 +25,15 @@ var (
 func TestMysqlErrCode(t *testing.T) {
 	var (
 		bigId = bigid.New(2)
-		ctx   = SetRoute(context.Background(), "test", bigId, false)
+		ctx   = cdbpool.SetRoute(context.Background(), "test", bigId, false)
 	)
 
+	srv.Handle("ori_delete", cdbpooltest.Delete(0))
+	srv.Handle("ori_insert", cdbpooltest.Sequence(
+		cdbpooltest.Insert(bigId, 1),
+		cdbpooltest.MysqlError(1062, "Duplicate entry"),
+	))
+
 	if _, err = db.ExecContext(ctx, "delete from test where 1=1"); err != nil {
 		t.Fatalf("clean db, err=%v", err)
 	}
@@ -29,7 +42,7 @@ func TestMysqlErrCode(t *testing.T) {
 		t.Fatalf("first insert: id=%v, err=%v", bigId, err)
 	}
 	if _, err = db.ExecContext(ctx, "insert into test(id, value) values(?, ?)", bigId, fmt.Sprint(bigId)); err != nil {
-		if dberr, ok := err.(*DBError); ok {
+		if dberr, ok := err.(*cdbpool.DBError); ok {
 			if 1062 == dberr.GetMysqlErrno() {
 				t.Log("ignore duplicated key")
 				return
@@ -37,13 +50,527 @@ func TestMysqlErrCode(t *testing.T) {
 		}
 		t.Fatalf("first insert: id=%v, err=%v", bigId, err)
 	}
+	t.Fatalf("second insert: id=%v, want duplicated key error", bigId)
+}
+
+func TestQueryNull(t *testing.T) {
+	var (
+		bigId = bigid.New(2)
+		ctx   = cdbpool.SetRoute(context.Background(), "test", bigId, false)
+		id    sql.NullInt64
+		value sql.NullString
+	)
+
+	nullDB, err := sql.Open("cdbpool", srv.DSN("test", "emptyAsNull=true"))
+	if err != nil {
+		t.Fatalf("open: err=%v", err)
+	}
+	defer nullDB.Close()
+
+	srv.Handle("ori_select", cdbpooltest.Select(cdbpooltest.Records(
+		[]string{"id", "value"},
+		[]interface{}{nil, nil},
+	)))
+
+	if err = nullDB.QueryRowContext(ctx, "select id, value from test where id=?", bigId).Scan(&id, &value); err != nil {
+		t.Fatalf("query: err=%v", err)
+	}
+
+	if id.Valid || value.Valid {
+		t.Errorf("got id=%v, value=%v, want NULL", id, value)
+	}
+
+	if req := srv.LastRequest().GetOriSelectReq(); req.GetTable() != "test" {
+		t.Errorf("unexpected request: %v", srv.LastRequest())
+	}
+}
+
+func TestQueryEmptyString(t *testing.T) {
+	var (
+		ctx   = cdbpool.SetRoute(context.Background(), "test", bigid.New(2), false)
+		value string
+	)
+
+	srv.Handle("ori_select", cdbpooltest.Select(cdbpooltest.Records(
+		[]string{"value"},
+		[]interface{}{nil},
+	)))
+
+	// Untyped empty values are scanned into strings by default.
+	if err = db.QueryRowContext(ctx, "select value from test where id=1").Scan(&value); err != nil {
+		t.Fatalf("query: err=%v", err)
+	}
+	if value != "" {
+		t.Errorf("got value=%q, want \"\"", value)
+	}
+}
+
+func TestShow(t *testing.T) {
+	var (
+		ctx   = cdbpool.SetRoute(context.Background(), "test", bigid.New(2), false)
+		table string
+	)
+
+	srv.Handle("ori_show", cdbpooltest.Show(cdbpooltest.Records(
+		[]string{"Tables_in_test"},
+		[]interface{}{"test"},
+	)))
+
+	if err = db.QueryRowContext(ctx, "show tables").Scan(&table); err != nil {
+		t.Fatalf("show tables: err=%v", err)
+	}
+
+	if table != "test" {
+		t.Errorf("show tables: got %q, want \"test\"", table)
+	}
+
+	if content := srv.LastRequest().GetOriShowReq().GetContent(); content != "show tables" {
+		t.Errorf("show content: got %q, want \"show tables\"", content)
+	}
+}
+
+func TestNamedArgs(t *testing.T) {
+	var (
+		bigId = bigid.New(2)
+		ctx   = cdbpool.SetRoute(context.Background(), "test", bigId, false)
+	)
+
+	srv.Handle("ori_delete", cdbpooltest.Delete(1))
+
+	if _, err = db.ExecContext(ctx, "delete from test where value = 'why?' and id = :id", sql.Named("id", bigId)); err != nil {
+		t.Fatalf("delete: err=%v", err)
+	}
+
+	want := fmt.Sprintf("value = 'why?' and id = %d", bigId)
+	if filter := srv.LastRequest().GetOriDeleteReq().GetComplexFilter(); !strings.Contains(filter, want) {
+		t.Errorf("delete filter: got %q, want %q", filter, want)
+	}
+}
+
+func TestPreparedStmt(t *testing.T) {
+	var (
+		ctx  = cdbpool.SetRoute(context.Background(), "test", bigid.New(2), false)
+		stmt *sql.Stmt
+	)
+
+	srv.Handle("ori_delete", cdbpooltest.Delete(1))
+
+	if stmt, err = db.PrepareContext(ctx, "delete from test where note = 'why?' and id = ?"); err != nil {
+		t.Fatalf("prepare: err=%v", err)
+	}
+	defer stmt.Close()
+
+	for i := 1; i <= 2; i++ {
+		if _, err = stmt.ExecContext(ctx, i); err != nil {
+			t.Fatalf("exec: err=%v", err)
+		}
+
+		want := fmt.Sprintf("note = 'why?' and id = %d", i)
+		if filter := srv.LastRequest().GetOriDeleteReq().GetComplexFilter(); !strings.Contains(filter, want) {
+			t.Errorf("exec: got filter %q, want %q", filter, want)
+		}
+	}
+}
+
+func TestExecWithoutPrepare(t *testing.T) {
+	ctx := cdbpool.SetRoute(context.Background(), "test", bigid.New(2), false)
+
+	// database/sql would report the count itself on a prepared statement.
+	_, err := db.ExecContext(ctx, "delete from test where id = ? and note = ?", 1)
+	if !errors.Is(err, cdbpool.ErrInvalidSQL) || !strings.Contains(err.Error(), "2 placeholders, got 1") {
+		t.Errorf("exec: got err %v, want the placeholder count error of the driver", err)
+	}
+	_, err = db.QueryContext(ctx, "select * from test where id = ? and note = ?", 1)
+	if !errors.Is(err, cdbpool.ErrInvalidSQL) || !strings.Contains(err.Error(), "2 placeholders, got 1") {
+		t.Errorf("query: got err %v, want the placeholder count error of the driver", err)
+	}
+
+	srv.Handle("transfer", cdbpooltest.OK())
+	srv.Handle("ori_delete", cdbpooltest.Delete(1))
+	srv.Handle("ori_select", cdbpooltest.Select(nil))
+
+	tx, err := db.BeginTx(cdbpool.SetRoute(context.Background(), "test", bigid.New(5), false), nil)
+	if err != nil {
+		t.Fatalf("begin: err=%v", err)
+	}
+	defer tx.Rollback()
+
+	// Statements without a route run on the route of the transaction.
+	if _, err = tx.ExecContext(context.Background(), "delete from test where id = ?", 1); err != nil {
+		t.Fatalf("exec in transaction: err=%v", err)
+	}
+	if req := srv.LastRequest(); req.GetBigid() != bigid.New(5) {
+		t.Errorf("exec in transaction: got bigid %v, want %v", req.GetBigid(), bigid.New(5))
+	}
+
+	rows, err := tx.QueryContext(context.Background(), "select * from test where id = ?", 1)
+	if err != nil {
+		t.Fatalf("query in transaction: err=%v", err)
+	}
+	rows.Close()
+	if req := srv.LastRequest(); req.GetCommand() != "ori_select" || req.GetBigid() != bigid.New(5) {
+		t.Errorf("query in transaction: got request %v, want bigid %v", req, bigid.New(5))
+	}
+}
+
+func TestRouteHint(t *testing.T) {
+	srv.Handle("ori_delete", cdbpooltest.Delete(1))
+
+	query := fmt.Sprintf("/*+ cdbpool: db=test bigid=%d */ delete from test where id=1", bigid.New(3))
+	if _, err = db.ExecContext(context.Background(), query); err != nil {
+		t.Fatalf("delete with hint: err=%v", err)
+	}
+
+	req := srv.LastRequest()
+	if req.GetBigid() != bigid.New(3) || strings.Contains(req.GetOriDeleteReq().GetComplexFilter(), "cdbpool") {
+		t.Errorf("unexpected request: %v", req)
+	}
+
+	ctx := cdbpool.SetRoute(context.Background(), "test", bigid.New(2), false)
+	if _, err = db.ExecContext(ctx, query); err != nil {
+		t.Fatalf("delete with hint and route: err=%v", err)
+	}
+	if req = srv.LastRequest(); req.GetBigid() != bigid.New(2) {
+		t.Errorf("context route must take precedence, got bigid %v", req.GetBigid())
+	}
+
+	if _, err = db.ExecContext(context.Background(), "delete from test where id=1"); err != cdbpool.ErrMissingRouteInfo {
+		t.Errorf("no route: got err %v, want %v", err, cdbpool.ErrMissingRouteInfo)
+	}
+}
+
+func TestRouteResolver(t *testing.T) {
+	cfg, err := cdbpool.ParseDSN(srv.DSN("test"))
+	if err != nil {
+		t.Fatalf("parse dsn: err=%v", err)
+	}
+	cfg.RouteResolver = cdbpool.NewShardKeyResolver(nil)
+
+	connector, err := cdbpool.NewConnector(cfg)
+	if err != nil {
+		t.Fatalf("new connector: err=%v", err)
+	}
+	resolverDB := sql.OpenDB(connector)
+	defer resolverDB.Close()
+
+	srv.Handle("ori_delete", cdbpooltest.Delete(1))
+
+	// The bigId of the statement wins over a wrong route.
+	ctx := cdbpool.SetRoute(context.Background(), "test", bigid.New(2), false)
+	if _, err = resolverDB.ExecContext(ctx, "delete from test where id=?", bigid.New(5)); err != nil {
+		t.Fatalf("delete: err=%v", err)
+	}
+	if req := srv.LastRequest(); req.GetBigid() != bigid.New(5) {
+		t.Errorf("resolved bigid: got %v, want %v", req.GetBigid(), bigid.New(5))
+	}
+
+	if _, err = resolverDB.ExecContext(context.Background(), "delete from test where id=?", bigid.New(6)); err != nil {
+		t.Fatalf("delete without route: err=%v", err)
+	}
+	if req := srv.LastRequest(); req.GetBigid() != bigid.New(6) || req.GetOriDeleteReq().GetDbname() != "test" {
+		t.Errorf("unexpected request: %v", req)
+	}
+}
+
+func TestConnectorHooks(t *testing.T) {
+	var (
+		ctx    = cdbpool.SetRoute(context.Background(), "test", bigid.New(2), false)
+		before []string
+		after  []string
+	)
+
+	cfg, err := cdbpool.ParseDSN(srv.DSN("test"))
+	if err != nil {
+		t.Fatalf("parse dsn: err=%v", err)
+	}
+	cfg.Hooks = &cdbpool.Hooks{
+		BeforeCall: func(ctx context.Context, req *cdbpool.CdbPoolRequest) {
+			before = append(before, req.GetCommand())
+		},
+		AfterCall: func(ctx context.Context, req *cdbpool.CdbPoolRequest, resp *cdbpool.CdbPoolResponse, err error, elapsed time.Duration) {
+			after = append(after, req.GetCommand())
+		},
+	}
+
+	connector, err := cdbpool.NewConnector(cfg)
+	if err != nil {
+		t.Fatalf("new connector: err=%v", err)
+	}
+	hookDB := sql.OpenDB(connector)
+	defer hookDB.Close()
+
+	srv.Handle("ori_delete", cdbpooltest.Delete(0))
+	if _, err = hookDB.ExecContext(ctx, "delete from test where 1=1"); err != nil {
+		t.Fatalf("delete: err=%v", err)
+	}
+
+	if len(before) != 1 || before[0] != "ori_delete" || len(after) != 1 || after[0] != "ori_delete" {
+		t.Errorf("hooks: got before=%v, after=%v, want [ori_delete]", before, after)
+	}
+}
+
+func TestBulkInsert(t *testing.T) {
+	ctx := cdbpool.SetRoute(context.Background(), "test", bigid.New(2), false)
+
+	srv.Handle("mulinsert", func(sess *cdbpooltest.Session, req *cdbpool.CdbPoolRequest) (*cdbpool.CdbPoolResponse, error) {
+		rows := len(req.GetMulinsertReq().GetValuelists())
+		return cdbpooltest.Insert(uint64(rows), uint32(rows))(sess, req)
+	})
+
+	bulkDB, err := sql.Open("cdbpool", srv.DSN("test", "maxAllowedPacket=200"))
+	if err != nil {
+		t.Fatalf("open: err=%v", err)
+	}
+	defer bulkDB.Close()
+
+	rows := make([][]interface{}, 20)
+	for i := range rows {
+		rows[i] = []interface{}{i, fmt.Sprintf("value-%d", i)}
+	}
+
+	srv.ResetRequests()
+	result, err := cdbpool.BulkInsert(ctx, bulkDB, "test", []string{"id", "value"}, rows)
+	if err != nil {
+		t.Fatalf("bulk insert: err=%v", err)
+	}
+
+	var (
+		requests = srv.Requests()
+		sent     int
+	)
+	for i, req := range requests {
+		values := req.GetMulinsertReq().GetValuelists()
+		if len(values) != result.Chunks[i].Rows || values[0].GetValues()[1] != fmt.Sprintf("value-%d", sent) {
+			t.Errorf("chunk %d: got request %v, want %d rows from row %d", i, req, result.Chunks[i].Rows, sent)
+		}
+		sent += len(values)
+	}
+	if len(result.Chunks) < 2 || len(requests) != len(result.Chunks) || sent != len(rows) || result.RowsAffected != int64(len(rows)) {
+		t.Errorf("got %d chunks, %d requests, %d rows sent, %d rows affected, want >= 2 chunks of %d rows",
+			len(result.Chunks), len(requests), sent, result.RowsAffected, len(rows))
+	}
+
+	srv.ResetRequests()
+	if result, err = cdbpool.BulkInsert(ctx, bulkDB, "test", []string{"id", "value"}, nil); err != nil || len(result.Chunks) != 0 {
+		t.Errorf("empty bulk insert: got %+v, err=%v", result, err)
+	}
+	if requests = srv.Requests(); len(requests) != 0 {
+		t.Errorf("empty bulk insert: got requests %v, want none", requests)
+	}
+
+	// Times are sent in the location of the connection.
+	locDB, err := sql.Open("cdbpool", srv.DSN("test", "loc=Asia%2FShanghai"))
+	if err != nil {
+		t.Fatalf("open: err=%v", err)
+	}
+	defer locDB.Close()
+
+	createdAt := time.Date(2017, 3, 1, 4, 30, 0, 0, time.UTC)
+	if _, err = cdbpool.BulkInsert(ctx, locDB, "test", []string{"id", "created_at"}, [][]interface{}{{1, createdAt}}); err != nil {
+		t.Fatalf("bulk insert: err=%v", err)
+	}
+	if values := srv.LastRequest().GetMulinsertReq().GetValuelists()[0].GetValues(); values[1] != "2017-03-01 12:30:00" {
+		t.Errorf("bulk insert time: got %q, want the Asia/Shanghai time", values[1])
+	}
+}
+
+func TestMultiplex(t *testing.T) {
+	var (
+		ctx      = cdbpool.SetRoute(context.Background(), "test", bigid.New(2), false)
+		mu       sync.Mutex
+		sessions = make(map[uint64]int)
+		lastSess uint64
+		last     = func() uint64 {
+			mu.Lock()
+			defer mu.Unlock()
+			return lastSess
+		}
+	)
+
+	srv.Handle("transfer", cdbpooltest.OK())
+	srv.Handle("ori_delete", func(sess *cdbpooltest.Session, req *cdbpool.CdbPoolRequest) (*cdbpool.CdbPoolResponse, error) {
+		mu.Lock()
+		sessions[sess.ID()]++
+		lastSess = sess.ID()
+		mu.Unlock()
+		return cdbpooltest.Delete(1)(sess, req)
+	})
+
+	muxDB, err := sql.Open("cdbpool", srv.DSN("test", "multiplex=true", "multiplexSessions=1"))
+	if err != nil {
+		t.Fatalf("open: err=%v", err)
+	}
+	defer muxDB.Close()
+
+	var (
+		conns = make([]*sql.Conn, 4)
+		wg    sync.WaitGroup
+		errs  = make(chan error, len(conns)*10)
+	)
+	for i := range conns {
+		if conns[i], err = muxDB.Conn(ctx); err != nil {
+			t.Fatalf("conn: err=%v", err)
+		}
+		defer conns[i].Close()
+	}
+
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn *sql.Conn) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				if _, err := conn.ExecContext(ctx, "delete from test where id = ?", i); err != nil {
+					errs <- err
+				}
+			}
+			if err := conn.PingContext(ctx); err != nil {
+				errs <- err
+			}
+		}(conn)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("multiplexed exec: err=%v", err)
+	}
+	mu.Lock()
+	if len(sessions) != 1 {
+		t.Fatalf("got %d sessions, want 1 shared session: %v", len(sessions), sessions)
+	}
+	mu.Unlock()
+	shared := last()
+
+	tx, err := conns[0].BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("begin: err=%v", err)
+	}
+	if _, err = tx.ExecContext(ctx, "delete from test where id = 1"); err != nil {
+		t.Fatalf("exec in transaction: err=%v", err)
+	}
+	if last() == shared {
+		t.Errorf("transaction runs on the shared session %d", shared)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatalf("commit: err=%v", err)
+	}
+
+	if _, err = conns[0].ExecContext(ctx, "delete from test where id = 1"); err != nil {
+		t.Fatalf("exec after transaction: err=%v", err)
+	}
+	if last() != shared {
+		t.Errorf("got session %d after the transaction, want the shared session %d", last(), shared)
+	}
+}
+
+func TestMultiplexOutOfOrder(t *testing.T) {
+	var (
+		ctx      = cdbpool.SetRoute(context.Background(), "test", bigid.New(2), false)
+		received = make(chan struct{})
+		release  = make(chan struct{})
+	)
+
+	// The request of id 0 is answered after the later ones, each answer
+	// carrying the id of its request as affected rows.
+	srv.Handle("ori_update", func(sess *cdbpooltest.Session, req *cdbpool.CdbPoolRequest) (*cdbpool.CdbPoolResponse, error) {
+		var id uint32
+		if _, err := fmt.Sscanf(req.GetOriUpdateReq().GetComplexFilter(), "id = %d", &id); err != nil {
+			return nil, err
+		}
+		if id == 0 {
+			close(received)
+			<-release
+		}
+		return cdbpooltest.Update(id)(sess, req)
+	})
+
+	muxDB, err := sql.Open("cdbpool", srv.DSN("test", "multiplex=true", "multiplexSessions=1"))
+	if err != nil {
+		t.Fatalf("open: err=%v", err)
+	}
+	defer muxDB.Close()
+
+	conns := make([]*sql.Conn, 4)
+	for i := range conns {
+		if conns[i], err = muxDB.Conn(ctx); err != nil {
+			t.Fatalf("conn: err=%v", err)
+		}
+		defer conns[i].Close()
+	}
+
+	exec := func(conn *sql.Conn, id int) error {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		result, err := conn.ExecContext(ctx, "update test set name = 'foo' where id = ?", id)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n != int64(id) {
+			return fmt.Errorf("id %d: got the response of id %d", id, n)
+		}
+		return nil
+	}
+
+	first := make(chan error, 1)
+	go func() {
+		first <- exec(conns[0], 0)
+	}()
+	<-received
+
+	for id := 1; id < len(conns); id++ {
+		if err = exec(conns[id], id); err != nil {
+			t.Errorf("exec before the delayed response: err=%v", err)
+		}
+	}
+	close(release)
+
+	if err = <-first; err != nil {
+		t.Errorf("exec of the delayed response: err=%v", err)
+	}
+}
+
+func TestMultiplexTxSessions(t *testing.T) {
+	ctx := cdbpool.SetRoute(context.Background(), "test", bigid.New(2), false)
+	srv.Handle("transfer", cdbpooltest.OK())
+
+	muxDB, err := sql.Open("cdbpool", srv.DSN("test", "multiplex=true", "multiplexTxSessions=1"))
+	if err != nil {
+		t.Fatalf("open: err=%v", err)
+	}
+	defer muxDB.Close()
+
+	tx, err := muxDB.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("begin: err=%v", err)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err = muxDB.BeginTx(timeoutCtx, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("begin over multiplexTxSessions: got err=%v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if err = tx.Commit(); err != nil {
+		t.Fatalf("commit: err=%v", err)
+	}
+	if tx, err = muxDB.BeginTx(ctx, nil); err != nil {
+		t.Fatalf("begin after commit: err=%v", err)
+	}
+	tx.Rollback()
 }
 
 func TestMain(m *testing.M) {
-	db, err = sql.Open("cdbpool", "tcp(127.0.0.1:9123)/test?timeout=30s&readTimeout=4s&writeTimeout=15s")
+	srv = cdbpooltest.NewServer()
+
+	db, err = sql.Open("cdbpool", srv.DSN("test", "timeout=30s", "readTimeout=4s", "writeTimeout=15s"))
 	if err != nil {
 		fmt.Printf("sql.Open() error: %v\n", err)
 		os.Exit(1)
 	}
-	os.Exit(m.Run())
+
+	code := m.Run()
+	db.Close()
+	srv.Close()
+	os.Exit(code)
 }
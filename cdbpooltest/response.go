@@ -0,0 +1,160 @@
+package cdbpooltest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stn81/cdbpool"
+)
+
+// Records builds the records of a result set. A nil value is sent as
+// NULL, other values are typed with cdbpool.EncodeValue.
+func Records(columns []string, rows ...[]interface{}) []*cdbpool.StoreRecord {
+	records := make([]*cdbpool.StoreRecord, len(rows))
+	for i, row := range rows {
+		r := &cdbpool.StoreRecord{
+			Units: make([]*cdbpool.KVPair, len(columns)),
+		}
+		for j, column := range columns {
+			kv := &cdbpool.KVPair{Key: column}
+			if row[j] != nil {
+				kv.Value, kv.Type, _ = cdbpool.EncodeValue(row[j])
+			}
+			r.Units[j] = kv
+		}
+		records[i] = r
+	}
+	return records
+}
+
+// Respond always answers with a copy of resp.
+func Respond(resp *cdbpool.CdbPoolResponse) HandlerFunc {
+	return func(sess *Session, req *cdbpool.CdbPoolRequest) (*cdbpool.CdbPoolResponse, error) {
+		return proto.Clone(resp).(*cdbpool.CdbPoolResponse), nil
+	}
+}
+
+// OK answers with success and no response body, e.g. for transfer.
+func OK() HandlerFunc {
+	return Respond(&cdbpool.CdbPoolResponse{})
+}
+
+// Select answers ori_select and select with records.
+func Select(records []*cdbpool.StoreRecord) HandlerFunc {
+	return Respond(&cdbpool.CdbPoolResponse{
+		Resp: &cdbpool.CdbPoolResponse_SelectResp{
+			SelectResp: &cdbpool.SelectResponse{Records: records},
+		},
+	})
+}
+
+// Show answers ori_show with records.
+func Show(records []*cdbpool.StoreRecord) HandlerFunc {
+	return Respond(&cdbpool.CdbPoolResponse{
+		Resp: &cdbpool.CdbPoolResponse_OriShowResp{
+			OriShowResp: &cdbpool.OriShowResponse{Records: records},
+		},
+	})
+}
+
+// Insert answers ori_insert, insert and mulinsert.
+func Insert(lastInsertId uint64, affectRows uint32) HandlerFunc {
+	return Respond(&cdbpool.CdbPoolResponse{
+		Resp: &cdbpool.CdbPoolResponse_InsertResp{
+			InsertResp: &cdbpool.InsertResponse{
+				LastInsertid: lastInsertId,
+				AffectRows:   affectRows,
+			},
+		},
+	})
+}
+
+// Update answers ori_update and update.
+func Update(affectRows uint32) HandlerFunc {
+	return Respond(&cdbpool.CdbPoolResponse{
+		Resp: &cdbpool.CdbPoolResponse_UpdateResp{
+			UpdateResp: &cdbpool.UpdateResponse{AffectRows: affectRows},
+		},
+	})
+}
+
+// Delete answers ori_delete and delete.
+func Delete(affectRows uint32) HandlerFunc {
+	return Respond(&cdbpool.CdbPoolResponse{
+		Resp: &cdbpool.CdbPoolResponse_DeleteResp{
+			DeleteResp: &cdbpool.DeleteResponse{AffectRows: affectRows},
+		},
+	})
+}
+
+// Stats answers stats with values typed by cdbpool.EncodeValue.
+func Stats(values map[string]interface{}) HandlerFunc {
+	stats := make([]*cdbpool.KVPair, 0, len(values))
+	for key, v := range values {
+		kv := &cdbpool.KVPair{Key: key}
+		kv.Value, kv.Type, _ = cdbpool.EncodeValue(v)
+		stats = append(stats, kv)
+	}
+
+	return Respond(&cdbpool.CdbPoolResponse{
+		Resp: &cdbpool.CdbPoolResponse_StatsResp{
+			StatsResp: &cdbpool.ServerStatsResponse{Stats: stats},
+		},
+	})
+}
+
+// Error answers with a failed result code.
+func Error(code cdbpool.ResultCode, msg string) HandlerFunc {
+	return Respond(&cdbpool.CdbPoolResponse{
+		Error:  int32(code),
+		ErrMsg: msg,
+	})
+}
+
+// MysqlError answers with RC_DB_MYSQL_QUERY and the given mysql errno.
+func MysqlError(errno uint32, msg string) HandlerFunc {
+	return func(sess *Session, req *cdbpool.CdbPoolRequest) (*cdbpool.CdbPoolResponse, error) {
+		return &cdbpool.CdbPoolResponse{
+			Error:  int32(cdbpool.ResultCode_RC_DB_MYSQL_QUERY),
+			ErrMsg: msg,
+			SqlInfo: &cdbpool.MysqlInfo{
+				MysqlErrno: errno,
+			},
+		}, nil
+	}
+}
+
+// Disconnect closes the client connection without replying.
+func Disconnect() HandlerFunc {
+	return func(sess *Session, req *cdbpool.CdbPoolRequest) (*cdbpool.CdbPoolResponse, error) {
+		return nil, ErrDisconnect
+	}
+}
+
+// Sequence answers the n-th request with the n-th handler, repeating the
+// last one once exhausted.
+func Sequence(handlers ...HandlerFunc) HandlerFunc {
+	var (
+		next = 0
+		mu   sync.Mutex
+	)
+
+	return func(sess *Session, req *cdbpool.CdbPoolRequest) (*cdbpool.CdbPoolResponse, error) {
+		mu.Lock()
+		h := handlers[next]
+		if next < len(handlers)-1 {
+			next++
+		}
+		mu.Unlock()
+		return h(sess, req)
+	}
+}
+
+// Delay answers with h after sleeping d, to simulate a slow server.
+func Delay(d time.Duration, h HandlerFunc) HandlerFunc {
+	return func(sess *Session, req *cdbpool.CdbPoolRequest) (*cdbpool.CdbPoolResponse, error) {
+		time.Sleep(d)
+		return h(sess, req)
+	}
+}
@@ -0,0 +1,344 @@
+// Package cdbpooltest provides an in-process fake cdbpool server for tests.
+//
+// The server speaks the cdbpool framing and answers each CdbPoolRequest with
+// the handler registered for its command:
+//
+//	srv := cdbpooltest.NewServer()
+//	defer srv.Close()
+//
+//	srv.Handle("ori_select", cdbpooltest.Select(
+//		cdbpooltest.Records([]string{"id", "name"}, []interface{}{1, "foo"}),
+//	))
+//	srv.Handle("ori_insert", cdbpooltest.MysqlError(1062, "duplicate entry"))
+//
+//	db, _ := sql.Open("cdbpool", srv.DSN("test"))
+//
+// The requests of a session are handled concurrently, so that the responses
+// to pipelined requests may be sent out of order, like a real server does.
+package cdbpooltest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stn81/cdbpool"
+)
+
+// ErrDisconnect, returned by a handler, closes the client connection
+// without replying.
+var ErrDisconnect = errors.New("cdbpooltest: disconnect")
+
+// HandlerFunc answers a request. Returning a non-nil error closes the
+// client connection.
+type HandlerFunc func(sess *Session, req *cdbpool.CdbPoolRequest) (*cdbpool.CdbPoolResponse, error)
+
+// Session is a client connection to the server.
+type Session struct {
+	id     uint64
+	conn   net.Conn
+	mu     sync.Mutex
+	values map[interface{}]interface{}
+	wmu    sync.Mutex // serializes the responses
+}
+
+// ID returns the unique id of the session.
+func (sess *Session) ID() uint64 {
+	return sess.id
+}
+
+// RemoteAddr returns the client address.
+func (sess *Session) RemoteAddr() net.Addr {
+	return sess.conn.RemoteAddr()
+}
+
+// Get returns the session value stored under key.
+func (sess *Session) Get(key interface{}) interface{} {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.values[key]
+}
+
+// Set stores a session value, e.g. an open transaction.
+func (sess *Session) Set(key, value interface{}) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.values[key] = value
+}
+
+// Close closes the client connection.
+func (sess *Session) Close() error {
+	return sess.conn.Close()
+}
+
+// write sends a response packet.
+func (sess *Session) write(header cdbpool.Header, body []byte) error {
+	sess.wmu.Lock()
+	defer sess.wmu.Unlock()
+
+	header.BodyLen = uint32(len(body))
+	if err := binary.Write(sess.conn, binary.BigEndian, &header); err != nil {
+		return err
+	}
+	_, err := sess.conn.Write(body)
+	return err
+}
+
+// Server is a fake cdbpool server listening on a local port.
+type Server struct {
+	ln       net.Listener
+	nextId   uint64
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	handlers map[string]HandlerFunc
+	requests []*cdbpool.CdbPoolRequest
+	sessions map[*Session]struct{}
+	onClose  []func(sess *Session)
+	closed   bool
+}
+
+// NewServer starts a server on an ephemeral port of 127.0.0.1. It panics
+// if the port can not be opened.
+func NewServer() *Server {
+	s, err := Listen("127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("cdbpooltest: failed to listen: %v", err))
+	}
+	return s
+}
+
+// Listen starts a server on addr.
+func Listen(addr string) (*Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		ln:       ln,
+		handlers: make(map[string]HandlerFunc),
+		sessions: make(map[*Session]struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// DSN returns a DSN connecting to the server, with optional params
+// such as "readTimeout=1s".
+func (s *Server) DSN(dbName string, params ...string) string {
+	dsn := fmt.Sprintf("tcp(%s)/%s", s.Addr(), dbName)
+	if len(params) > 0 {
+		dsn += "?" + strings.Join(params, "&")
+	}
+	return dsn
+}
+
+// Handle registers the handler for command, replacing any previous one.
+// Commands without handler are answered with RC_BAD_COMMAND.
+func (s *Server) Handle(command string, h HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[command] = h
+}
+
+// OnSessionClose registers fn to be called when a client connection ends.
+func (s *Server) OnSessionClose(fn func(sess *Session)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onClose = append(s.onClose, fn)
+}
+
+// Requests returns the requests received so far, in order.
+func (s *Server) Requests() []*cdbpool.CdbPoolRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reqs := make([]*cdbpool.CdbPoolRequest, len(s.requests))
+	copy(reqs, s.requests)
+	return reqs
+}
+
+// LastRequest returns the last request received, or nil.
+func (s *Server) LastRequest() *cdbpool.CdbPoolRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.requests) == 0 {
+		return nil
+	}
+	return s.requests[len(s.requests)-1]
+}
+
+// ResetRequests forgets the requests received so far.
+func (s *Server) ResetRequests() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = nil
+}
+
+// CloseClientConnections closes all the client connections, simulating a
+// server restart. The server keeps accepting new connections.
+func (s *Server) CloseClientConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for sess := range s.sessions {
+		sess.Close()
+	}
+}
+
+// Close stops the server and closes all the client connections.
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.ln.Close()
+	for sess := range s.sessions {
+		sess.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		sess := &Session{
+			id:     atomic.AddUint64(&s.nextId, 1),
+			conn:   conn,
+			values: make(map[interface{}]interface{}),
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.sessions[sess] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serveSession(sess)
+	}
+}
+
+func (s *Server) serveSession(sess *Session) {
+	var pending sync.WaitGroup
+
+	defer s.wg.Done()
+	defer func() {
+		sess.Close()
+		pending.Wait()
+
+		s.mu.Lock()
+		delete(s.sessions, sess)
+		onClose := s.onClose
+		s.mu.Unlock()
+
+		for _, fn := range onClose {
+			fn(sess)
+		}
+	}()
+
+	var (
+		reader = bufio.NewReader(sess.conn)
+		header cdbpool.Header
+	)
+
+	for {
+		if err := binary.Read(reader, binary.BigEndian, &header); err != nil {
+			return
+		}
+
+		body := make([]byte, header.BodyLen)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return
+		}
+
+		if header.Command == cdbpool.CmdPing {
+			if err := sess.write(header, nil); err != nil {
+				return
+			}
+			continue
+		}
+
+		req := &cdbpool.CdbPoolRequest{}
+		if err := proto.Unmarshal(body, req); err != nil {
+			return
+		}
+
+		pending.Add(1)
+		go func(header cdbpool.Header) {
+			defer pending.Done()
+			if err := s.reply(sess, header, req); err != nil {
+				sess.Close()
+			}
+		}(header)
+	}
+}
+
+// reply handles req and sends the response with the header of the request.
+func (s *Server) reply(sess *Session, header cdbpool.Header, req *cdbpool.CdbPoolRequest) error {
+	resp, err := s.handle(sess, req)
+	if err != nil {
+		return err
+	}
+
+	if resp.Command == "" {
+		resp.Command = req.Command
+	}
+	if resp.Logid == "" {
+		resp.Logid = req.Logid
+	}
+
+	body, err := proto.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return sess.write(header, body)
+}
+
+func (s *Server) handle(sess *Session, req *cdbpool.CdbPoolRequest) (*cdbpool.CdbPoolResponse, error) {
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	h, ok := s.handlers[req.Command]
+	s.mu.Unlock()
+
+	if !ok {
+		return &cdbpool.CdbPoolResponse{
+			Error:  int32(cdbpool.ResultCode_RC_BAD_COMMAND),
+			ErrMsg: fmt.Sprintf("unknown command: %v", req.Command),
+		}, nil
+	}
+
+	resp, err := h(sess, req)
+	if err == nil && resp == nil {
+		resp = &cdbpool.CdbPoolResponse{}
+	}
+	return resp, err
+}
@@ -3,82 +3,576 @@ package cdbpool
 import (
 	"context"
 	"database/sql"
-	"math/rand"
+	"errors"
+	"fmt"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/stn81/log"
 )
 
+// NodeErrors maps node addresses to the error returned by that node.
+type NodeErrors map[string]error
+
+func (e NodeErrors) Error() string {
+	addrs := make([]string, 0, len(e))
+	for addr := range e {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	msgs := make([]string, len(addrs))
+	for i, addr := range addrs {
+		msgs[i] = fmt.Sprintf("%v: %v", addr, e[addr])
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ReloadSpec describes a reload_mysql request sent to every node.
+type ReloadSpec struct {
+	Reload string // Sent as ReloadMysqlRequest.reload
+	DryRun bool   // Only ping the nodes
+}
+
+// ReloadResult is the outcome of reload_mysql on a single node.
+type ReloadResult struct {
+	Addr    string
+	Err     error
+	Elapsed time.Duration
+}
+
+// ReloadReport gathers the outcome of reload_mysql on every node,
+// sorted by node address.
+type ReloadReport struct {
+	DryRun  bool
+	Results []ReloadResult
+}
+
+// Err returns the failed nodes as NodeErrors, or nil if all succeeded.
+func (r *ReloadReport) Err() error {
+	errs := make(NodeErrors)
+	for _, result := range r.Results {
+		if result.Err != nil {
+			errs[result.Addr] = result.Err
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+const defaultDrainTimeout = 30 * time.Second
+
+var (
+	errNoNodes       = errors.New("cdbpool: cluster has no node")
+	errClusterClosed = errors.New("cdbpool: cluster closed")
+)
+
 type Cluster struct {
-	addrs []string
-	pools []*sql.DB
+	conf        *Config
+	opts        *clusterOptions
+	readLatency latencyWindow // latency of the reads, for adaptive hedging
+
+	// healthMu guards health, apart from mu which the checker takes to list
+	// the nodes while it is being stopped.
+	healthMu sync.Mutex
+	health   *healthChecker
+
+	mu           sync.RWMutex
+	nodes        []*Node
+	balancer     Balancer
+	drainTimeout time.Duration
+	poolOpts     []func(db *sql.DB) // pool settings applied to added nodes
+	closed       chan struct{}
+	draining     sync.WaitGroup
 }
 
+// NewCluster opens a cluster like OpenCluster, and exits the process if
+// the DSN is invalid.
 func NewCluster(dsn string) *Cluster {
+	cluster, err := OpenCluster(dsn)
+	if err != nil {
+		log.Fatal(mctx, "failed to open cluster", "dsn", dsn, "error", err)
+	}
+	return cluster
+}
+
+// OpenCluster opens one pool per node of the DSN address list, given as
+// comma separated addr or addr#weight. The pools connect lazily, so that
+// only an invalid DSN or option returns an error.
+func OpenCluster(dsn string, opts ...ClusterOption) (*Cluster, error) {
 	conf, err := ParseDSN(dsn)
 	if err != nil {
-		log.Fatal(mctx, "invalid dsn", "dsn", dsn, "error", err)
+		return nil, err
+	}
+
+	o := &clusterOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	for _, fn := range o.configure {
+		fn(conf)
 	}
 
 	var (
-		hosts = strings.Split(conf.Addr, ",")
-		addrs = make([]string, 0, len(hosts))
+		cluster = &Cluster{
+			conf:         conf,
+			opts:         o,
+			drainTimeout: defaultDrainTimeout,
+			poolOpts:     o.poolOpts,
+			closed:       make(chan struct{}),
+		}
+		weighted bool
 	)
+	if o.drainTimeout > 0 {
+		cluster.drainTimeout = o.drainTimeout
+	}
 
-	for _, host := range hosts {
-		conf.Addr = strings.TrimSpace(host)
-		if len(conf.Addr) > 0 {
-			addrs = append(addrs, conf.FormatDSN())
+	for _, host := range strings.Split(conf.Addr, ",") {
+		if host = strings.TrimSpace(host); len(host) == 0 {
+			continue
+		}
+
+		node, err := cluster.openNode(host)
+		if err != nil {
+			cluster.Close()
+			return nil, err
+		}
+		weighted = weighted || node.Weight != 1
+		cluster.nodes = append(cluster.nodes, node)
+	}
+	if len(cluster.nodes) == 0 {
+		return nil, errNoNodes
+	}
+
+	switch {
+	case o.balancer != nil:
+		cluster.balancer = o.balancer
+	case len(conf.Balancer) > 0:
+		if cluster.balancer, err = newBalancer(conf.Balancer); err != nil {
+			cluster.Close()
+			return nil, err
 		}
+	case weighted:
+		cluster.balancer = NewWeightedBalancer()
+	default:
+		cluster.balancer = NewRandomBalancer()
+	}
+	cluster.setBalancerNodes()
+
+	if o.health != nil {
+		cluster.EnableHealthCheck(*o.health)
+	}
+	return cluster, nil
+}
+
+// openNode opens the pool of the node given as addr or addr#weight, from a
+// connector with a copy of the cluster config, and applies the pool options.
+func (c *Cluster) openNode(host string) (*Node, error) {
+	node, err := parseNode(host)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeConf := c.conf.Clone()
+	nodeConf.Addr = node.Addr
+	for _, fn := range c.opts.nodeConfig[node.Addr] {
+		fn(nodeConf)
+	}
+	node.reads = &c.readLatency
+	nodeConf.Hooks = node.hooks(nodeConf.Hooks)
+
+	connector, err := NewConnector(nodeConf)
+	if err != nil {
+		return nil, err
+	}
+
+	node.DB = sql.OpenDB(connector)
+	for _, opt := range c.poolOpts {
+		opt(node.DB)
+	}
+	for _, opt := range c.opts.nodePool[node.Addr] {
+		opt(node.DB)
+	}
+	return node, nil
+}
+
+// SetBalancer replaces the balancer picking the node of GetDB. By default,
+// it is given by the DSN param balancer, or is the weighted balancer if the
+// DSN address list has weights, or the random balancer otherwise.
+func (c *Cluster) SetBalancer(b Balancer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.balancer = b
+	c.setBalancerNodes()
+}
+
+// setBalancerNodes tells the balancer the nodes of the cluster. c.mu must
+// be held.
+func (c *Cluster) setBalancerNodes() {
+	if b, ok := c.balancer.(nodesSetter); ok {
+		b.setNodes(c.nodes)
+	}
+}
+
+// SetDrainTimeout sets how long UpdateNodes waits for the connections of
+// a removed node to be released before closing its pool, 30s by default.
+func (c *Cluster) SetDrainTimeout(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.drainTimeout = d
+}
+
+// EnableHealthCheck starts a background health checker which pings every
+// node, ejects the nodes failing conf.FailThreshold consecutive probes from
+// GetDB, and re-admits them after conf.SuccessThreshold successful probes.
+// It replaces the running health checker, if any.
+func (c *Cluster) EnableHealthCheck(conf HealthCheckConfig) {
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+
+	select {
+	case <-c.closed:
+		return
+	default:
+	}
+
+	if c.health != nil {
+		c.health.close()
+	}
+	c.health = newHealthChecker(c, conf)
+	c.health.start()
+}
+
+// Nodes returns a snapshot of the state of every node.
+func (c *Cluster) Nodes() []NodeStatus {
+	nodes := c.getNodes()
+	status := make([]NodeStatus, len(nodes))
+	for i, node := range nodes {
+		status[i] = node.Status()
+	}
+	return status
+}
+
+type nodeKey struct {
+	addr   string
+	weight int
+}
+
+// UpdateNodes replaces the nodes of the cluster by addrs, given as addr or
+// addr#weight. Nodes already in the cluster keep their pool and state, new
+// nodes are opened, and removed nodes stop being picked at once while their
+// pool is closed in the background when its connections are released, or
+// after the drain timeout. A node whose weight changed is replaced. An
+// address listed twice is only taken once, with its first weight. It fails
+// once the cluster is closed.
+func (c *Cluster) UpdateNodes(addrs []string) error {
+	var hosts []string
+	for _, addr := range addrs {
+		if addr = strings.TrimSpace(addr); len(addr) > 0 {
+			hosts = append(hosts, addr)
+		}
+	}
+	if len(hosts) == 0 {
+		return errNoNodes
 	}
 
-	cluster := &Cluster{
-		addrs: make([]string, len(addrs)),
-		pools: make([]*sql.DB, len(addrs)),
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Close closes c.closed under c.mu, before waiting for the drains.
+	select {
+	case <-c.closed:
+		return errClusterClosed
+	default:
+	}
+
+	current := make(map[nodeKey]*Node, len(c.nodes))
+	for _, node := range c.nodes {
+		current[nodeKey{node.Addr, node.Weight}] = node
 	}
-	copy(cluster.addrs, addrs)
 
-	for idx, addr := range cluster.addrs {
-		db, err := sql.Open("cdbpool", addr)
+	var (
+		nodes  = make([]*Node, 0, len(hosts))
+		opened []*Node
+		seen   = make(map[string]bool, len(hosts))
+	)
+	for _, host := range hosts {
+		parsed, err := parseNode(host)
+		if err == nil {
+			if seen[parsed.Addr] {
+				continue
+			}
+			seen[parsed.Addr] = true
+
+			key := nodeKey{parsed.Addr, parsed.Weight}
+			if node, ok := current[key]; ok {
+				nodes = append(nodes, node)
+				delete(current, key)
+				continue
+			}
+		}
+
+		node, err := c.openNode(host)
 		if err != nil {
-			log.Fatal(mctx, "failed to open database", "id", idx, "addr", addr, "error", err)
+			for _, node := range opened {
+				node.DB.Close()
+			}
+			return err
 		}
+		nodes = append(nodes, node)
+		opened = append(opened, node)
+	}
 
-		cluster.pools[idx] = db
+	for _, node := range opened {
+		log.Info(mctx, "node added", "addr", node.Addr, "weight", node.Weight)
+	}
+	for _, node := range current {
+		log.Info(mctx, "node removed", "addr", node.Addr, "weight", node.Weight)
+		c.draining.Add(1)
+		go c.drain(node, c.drainTimeout)
+	}
+
+	c.nodes = nodes
+	c.setBalancerNodes()
+	return nil
+}
+
+// drain closes the pool of a removed node once its connections are
+// released, after timeout, or when the cluster is closed.
+func (c *Cluster) drain(node *Node, timeout time.Duration) {
+	defer c.draining.Done()
+	defer node.DB.Close()
+
+	var (
+		timer  = time.NewTimer(timeout)
+		ticker = time.NewTicker(100 * time.Millisecond)
+	)
+	defer timer.Stop()
+	defer ticker.Stop()
+
+	for node.DB.Stats().InUse > 0 {
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			log.Error(mctx, "drain timeout, closing busy node", "addr", node.Addr, "in_use", node.DB.Stats().InUse)
+			return
+		case <-c.closed:
+			return
+		}
 	}
-	return cluster
 }
 
 func (c *Cluster) Close() (err error) {
-	for _, pool := range c.pools {
-		pool.Close()
+	c.mu.Lock()
+	select {
+	case <-c.closed:
+	default:
+		close(c.closed)
+	}
+	c.mu.Unlock()
+
+	// closed first, so that EnableHealthCheck starts no checker afterwards
+	c.healthMu.Lock()
+	if c.health != nil {
+		c.health.close()
+		c.health = nil
+	}
+	c.healthMu.Unlock()
+
+	c.mu.Lock()
+	for _, node := range c.nodes {
+		node.DB.Close()
 	}
+	c.mu.Unlock()
+
+	c.draining.Wait()
 	return
 }
 
-func (c *Cluster) SetMaxIdleConns(n int) {
-	for _, pool := range c.pools {
-		pool.SetMaxIdleConns(n)
+// setPool applies opt to the pools of the current and future nodes.
+func (c *Cluster) setPool(opt func(db *sql.DB)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.poolOpts = append(c.poolOpts, opt)
+	for _, node := range c.nodes {
+		opt(node.DB)
+		for _, nodeOpt := range c.opts.nodePool[node.Addr] {
+			nodeOpt(node.DB)
+		}
 	}
 }
 
+func (c *Cluster) SetMaxIdleConns(n int) {
+	c.setPool(func(db *sql.DB) {
+		db.SetMaxIdleConns(n)
+	})
+}
+
 func (c *Cluster) SetMaxOpenConns(n int) {
-	for _, pool := range c.pools {
-		pool.SetMaxOpenConns(n)
-	}
+	c.setPool(func(db *sql.DB) {
+		db.SetMaxOpenConns(n)
+	})
 }
 
 func (c *Cluster) SetConnMaxLifetime(d time.Duration) {
-	for _, pool := range c.pools {
-		pool.SetConnMaxLifetime(d)
-	}
+	c.setPool(func(db *sql.DB) {
+		db.SetConnMaxLifetime(d)
+	})
 }
 
+// GetDB returns a DB routed to bigId on a healthy node picked by the
+// balancer. If no node is healthy, it is picked among all nodes.
 func (c *Cluster) GetDB(ctx context.Context, dbName string, bigId uint64, offline bool) *DB {
+	ctx = SetRoute(ctx, dbName, bigId, offline)
+	node := c.getBalancer().Pick(GetRoute(ctx), c.healthyNodes())
 	db := &DB{
-		ctx: SetRoute(ctx, dbName, bigId, offline),
-		DB:  c.pools[rand.Intn(len(c.pools))],
+		ctx:     ctx,
+		DB:      node.DB,
+		cluster: c,
+		node:    node,
 	}
 	return db
 }
+
+func (c *Cluster) getBalancer() Balancer {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.balancer
+}
+
+// getNodes returns the current nodes. The slice must not be modified.
+func (c *Cluster) getNodes() []*Node {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.nodes
+}
+
+// healthyNodes returns the healthy nodes, or all nodes if none is healthy.
+func (c *Cluster) healthyNodes() []*Node {
+	nodes := c.getNodes()
+	healthy := make([]*Node, 0, len(nodes))
+	for _, node := range nodes {
+		if node.Healthy() {
+			healthy = append(healthy, node)
+		}
+	}
+
+	if len(healthy) == 0 {
+		return nodes
+	}
+	return healthy
+}
+
+// ServerStats returns the statistics of every node in the cluster, keyed by
+// node address. Nodes which failed are reported in the returned NodeErrors.
+func (c *Cluster) ServerStats(ctx context.Context) (map[string]*ServerStats, error) {
+	var (
+		stats = make(map[string]*ServerStats)
+		mu    sync.Mutex
+	)
+
+	errs := eachNode(ctx, c.getNodes(), func(host string, dbc *Conn) error {
+		s, err := dbc.ServerStats(ctx)
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		stats[host] = s
+		mu.Unlock()
+		return nil
+	})
+
+	if len(errs) > 0 {
+		return stats, errs
+	}
+	return stats, nil
+}
+
+// ReloadMysql sends reload_mysql to every node of the cluster. With
+// spec.DryRun, the nodes are only pinged.
+func (c *Cluster) ReloadMysql(ctx context.Context, spec ReloadSpec) *ReloadReport {
+	var (
+		nodes   = c.getNodes()
+		elapsed = make(map[string]time.Duration, len(nodes))
+		mu      sync.Mutex
+	)
+
+	errs := eachNode(ctx, nodes, func(host string, dbc *Conn) (err error) {
+		start := time.Now()
+		defer func() {
+			mu.Lock()
+			elapsed[host] = time.Since(start)
+			mu.Unlock()
+		}()
+
+		if spec.DryRun {
+			return dbc.Ping(ctx)
+		}
+		return dbc.ReloadMysql(ctx, spec.Reload)
+	})
+
+	report := &ReloadReport{
+		DryRun:  spec.DryRun,
+		Results: make([]ReloadResult, len(nodes)),
+	}
+	for i, node := range nodes {
+		report.Results[i] = ReloadResult{
+			Addr:    node.Addr,
+			Err:     errs[node.Addr],
+			Elapsed: elapsed[node.Addr],
+		}
+	}
+	sort.Slice(report.Results, func(i, j int) bool {
+		return report.Results[i].Addr < report.Results[j].Addr
+	})
+	return report
+}
+
+// eachNode runs fn concurrently on a connection of every node and returns
+// the failures keyed by node address.
+func eachNode(ctx context.Context, nodes []*Node, fn func(host string, dbc *Conn) error) NodeErrors {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs = make(NodeErrors)
+	)
+
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node *Node) {
+			defer wg.Done()
+
+			err := rawConn(ctx, node.DB, func(dbc *Conn) error {
+				return fn(node.Addr, dbc)
+			})
+			if err != nil {
+				mu.Lock()
+				errs[node.Addr] = err
+				mu.Unlock()
+			}
+		}(node)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// rawConn runs fn on a driver connection taken from pool.
+func rawConn(ctx context.Context, pool *sql.DB, fn func(dbc *Conn) error) error {
+	conn, err := pool.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		return fn(driverConn.(*Conn))
+	})
+}
@@ -0,0 +1,98 @@
+package cdbpool
+
+import (
+	"database/sql"
+	"time"
+)
+
+// ClusterOption configures a Cluster opened by OpenCluster.
+type ClusterOption func(opts *clusterOptions)
+
+type clusterOptions struct {
+	configure    []func(cfg *Config)
+	poolOpts     []func(db *sql.DB)
+	nodeConfig   map[string][]func(cfg *Config)
+	nodePool     map[string][]func(db *sql.DB)
+	balancer     Balancer
+	health       *HealthCheckConfig
+	drainTimeout time.Duration
+}
+
+// WithConfig modifies the Config parsed from the DSN before the nodes are
+// opened, to set the fields which can not be given in a DSN.
+func WithConfig(fn func(cfg *Config)) ClusterOption {
+	return func(opts *clusterOptions) {
+		opts.configure = append(opts.configure, fn)
+	}
+}
+
+// WithMaxOpenConns sets the maximum number of open connections per node.
+func WithMaxOpenConns(n int) ClusterOption {
+	return withPool(func(db *sql.DB) {
+		db.SetMaxOpenConns(n)
+	})
+}
+
+// WithMaxIdleConns sets the maximum number of idle connections per node.
+func WithMaxIdleConns(n int) ClusterOption {
+	return withPool(func(db *sql.DB) {
+		db.SetMaxIdleConns(n)
+	})
+}
+
+// WithConnMaxLifetime sets the maximum lifetime of the connections.
+func WithConnMaxLifetime(d time.Duration) ClusterOption {
+	return withPool(func(db *sql.DB) {
+		db.SetConnMaxLifetime(d)
+	})
+}
+
+func withPool(fn func(db *sql.DB)) ClusterOption {
+	return func(opts *clusterOptions) {
+		opts.poolOpts = append(opts.poolOpts, fn)
+	}
+}
+
+// WithNodeConfig modifies the Config of the node addr, after the options
+// applying to every node.
+func WithNodeConfig(addr string, fn func(cfg *Config)) ClusterOption {
+	return func(opts *clusterOptions) {
+		if opts.nodeConfig == nil {
+			opts.nodeConfig = make(map[string][]func(cfg *Config))
+		}
+		opts.nodeConfig[addr] = append(opts.nodeConfig[addr], fn)
+	}
+}
+
+// WithNodePool modifies the pool of the node addr, such as its pool sizes,
+// after the options applying to every node.
+func WithNodePool(addr string, fn func(db *sql.DB)) ClusterOption {
+	return func(opts *clusterOptions) {
+		if opts.nodePool == nil {
+			opts.nodePool = make(map[string][]func(db *sql.DB))
+		}
+		opts.nodePool[addr] = append(opts.nodePool[addr], fn)
+	}
+}
+
+// WithBalancer sets the balancer, overriding the DSN param balancer.
+func WithBalancer(b Balancer) ClusterOption {
+	return func(opts *clusterOptions) {
+		opts.balancer = b
+	}
+}
+
+// WithHealthCheck starts the health checker, see EnableHealthCheck.
+func WithHealthCheck(conf HealthCheckConfig) ClusterOption {
+	return func(opts *clusterOptions) {
+		opts.health = &conf
+	}
+}
+
+// WithDrainTimeout sets the drain timeout of removed nodes, see
+// SetDrainTimeout.
+func WithDrainTimeout(d time.Duration) ClusterOption {
+	return func(opts *clusterOptions) {
+		opts.drainTimeout = d
+	}
+}
@@ -0,0 +1,519 @@
+package cdbpool_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stn81/bigid"
+	"github.com/stn81/cdbpool"
+	"github.com/stn81/cdbpool/cdbpooltest"
+)
+
+// deadAddr returns an address nobody listens on.
+func deadAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: err=%v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func waitNodeState(t *testing.T, cluster *cdbpool.Cluster, addr string, state cdbpool.NodeState) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, node := range cluster.Nodes() {
+			if node.Addr == addr && node.State == state {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("node %v: want state %v, got %+v", addr, state, cluster.Nodes())
+}
+
+func TestClusterHealthCheck(t *testing.T) {
+	dead := deadAddr(t)
+	cluster := cdbpool.NewCluster(fmt.Sprintf("tcp(%s,%s)/test?timeout=1s", srv.Addr(), dead))
+	defer cluster.Close()
+
+	cluster.EnableHealthCheck(cdbpool.HealthCheckConfig{
+		Interval:         10 * time.Millisecond,
+		FailThreshold:    2,
+		SuccessThreshold: 1,
+	})
+	waitNodeState(t, cluster, dead, cdbpool.NodeUnhealthy)
+
+	for _, node := range cluster.Nodes() {
+		if node.Addr == srv.Addr() && node.State != cdbpool.NodeHealthy {
+			t.Fatalf("node %v: got state %v, want healthy", node.Addr, node.State)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		db := cluster.GetDB(context.Background(), "test", bigid.New(2), false)
+		if err := db.Ping(); err != nil {
+			t.Fatalf("ping %d: err=%v", i, err)
+		}
+	}
+}
+
+// healthCheckers returns the number of running health checkers.
+func healthCheckers() int {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	return strings.Count(string(buf), "created by github.com/stn81/cdbpool.(*healthChecker).start")
+}
+
+func TestClusterHealthCheckRestart(t *testing.T) {
+	cluster := cdbpool.NewCluster(fmt.Sprintf("tcp(%s)/test", srv.Addr()))
+
+	conf := cdbpool.HealthCheckConfig{Interval: 10 * time.Millisecond}
+	for i := 0; i < 3; i++ {
+		cluster.EnableHealthCheck(conf)
+	}
+	if n := healthCheckers(); n != 1 {
+		t.Errorf("got %d health checkers, want 1", n)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			cluster.EnableHealthCheck(conf)
+		}
+	}()
+	cluster.Close()
+	<-done
+
+	if n := healthCheckers(); n != 0 {
+		t.Errorf("got %d health checkers after Close, want 0", n)
+	}
+}
+
+func TestClusterSetConcurrently(t *testing.T) {
+	srv2 := cdbpooltest.NewServer()
+	defer srv2.Close()
+
+	cluster := cdbpool.NewCluster(fmt.Sprintf("tcp(%s,%s)/test", srv.Addr(), srv2.Addr()))
+	defer cluster.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			cluster.SetBalancer(cdbpool.NewRandomBalancer())
+			cluster.SetDrainTimeout(time.Second)
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		cluster.GetDB(context.Background(), "test", bigid.New(2), false)
+		addrs := []string{srv.Addr()}
+		if i%2 == 0 {
+			addrs = append(addrs, srv2.Addr())
+		}
+		if err := cluster.UpdateNodes(addrs); err != nil {
+			t.Fatalf("update nodes: err=%v", err)
+		}
+	}
+	<-done
+}
+
+func nodeAddrs(cluster *cdbpool.Cluster) []string {
+	var addrs []string
+	for _, node := range cluster.Nodes() {
+		addrs = append(addrs, node.Addr)
+	}
+	return addrs
+}
+
+func TestClusterUpdateNodes(t *testing.T) {
+	srv2 := cdbpooltest.NewServer()
+	defer srv2.Close()
+
+	cluster := cdbpool.NewCluster(srv.DSN("test"))
+	defer cluster.Close()
+	cluster.SetDrainTimeout(time.Second)
+
+	db := cluster.GetDB(context.Background(), "test", bigid.New(2), false)
+	if err := db.Ping(); err != nil {
+		t.Fatalf("ping: err=%v", err)
+	}
+
+	if err := cluster.UpdateNodes([]string{srv2.Addr()}); err != nil {
+		t.Fatalf("update nodes: err=%v", err)
+	}
+	if addrs := nodeAddrs(cluster); len(addrs) != 1 || addrs[0] != srv2.Addr() {
+		t.Fatalf("nodes: got %v, want [%v]", addrs, srv2.Addr())
+	}
+
+	// The removed pool is closed once drained.
+	deadline := time.Now().Add(5 * time.Second)
+	for db.Ping() == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("removed node still open")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := cluster.GetDB(context.Background(), "test", bigid.New(2), false).Ping(); err != nil {
+		t.Fatalf("ping new node: err=%v", err)
+	}
+
+	if err := cluster.UpdateNodes([]string{srv2.Addr(), srv2.Addr() + "#2", " " + srv2.Addr()}); err != nil {
+		t.Fatalf("update nodes: err=%v", err)
+	}
+	if nodes := cluster.Nodes(); len(nodes) != 1 || nodes[0].Addr != srv2.Addr() || nodes[0].Weight != 1 {
+		t.Errorf("duplicate addresses: got %+v, want a single %v", nodes, srv2.Addr())
+	}
+
+	if err := cluster.UpdateNodes(nil); err == nil {
+		t.Errorf("update to no node: got nil error")
+	}
+
+	cluster.Close()
+	if err := cluster.UpdateNodes([]string{srv.Addr()}); err == nil {
+		t.Errorf("update after close: got nil error")
+	}
+	if addrs := nodeAddrs(cluster); len(addrs) != 1 || addrs[0] != srv2.Addr() {
+		t.Errorf("nodes after close: got %v, want [%v]", addrs, srv2.Addr())
+	}
+}
+
+func TestWatchNodesFile(t *testing.T) {
+	srv2 := cdbpooltest.NewServer()
+	defer srv2.Close()
+
+	dir, err := ioutil.TempDir("", "cdbpool")
+	if err != nil {
+		t.Fatalf("temp dir: err=%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "nodes.yaml")
+	if err = ioutil.WriteFile(path, []byte(fmt.Sprintf("nodes:\n  - %s\n", srv.Addr())), 0644); err != nil {
+		t.Fatalf("write: err=%v", err)
+	}
+
+	cluster := cdbpool.NewCluster(srv2.DSN("test"))
+	defer cluster.Close()
+
+	watcher, err := cdbpool.WatchNodesFile(cluster, path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("watch: err=%v", err)
+	}
+	defer watcher.Close()
+
+	if addrs := nodeAddrs(cluster); len(addrs) != 1 || addrs[0] != srv.Addr() {
+		t.Fatalf("nodes: got %v, want [%v]", addrs, srv.Addr())
+	}
+
+	if err = ioutil.WriteFile(path, []byte(fmt.Sprintf("[%s, %s#2]\n", srv.Addr(), srv2.Addr())), 0644); err != nil {
+		t.Fatalf("write: err=%v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(nodeAddrs(cluster)) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("nodes file change not applied: %v", nodeAddrs(cluster))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A change keeping the size and modification time is still applied.
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: err=%v", err)
+	}
+	if err = ioutil.WriteFile(path, []byte(fmt.Sprintf("[%s, %s#3]\n", srv.Addr(), srv2.Addr())), 0644); err != nil {
+		t.Fatalf("write: err=%v", err)
+	}
+	if err = os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatalf("chtimes: err=%v", err)
+	}
+
+	for {
+		nodes := cluster.Nodes()
+		if len(nodes) == 2 && nodes[1].Weight == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("nodes file change not applied: %+v", nodes)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOpenCluster(t *testing.T) {
+	if _, err := cdbpool.OpenCluster("tcp(127.0.0.1:9123/test"); err == nil {
+		t.Errorf("invalid dsn: got nil error")
+	}
+	if _, err := cdbpool.OpenCluster("tcp(127.0.0.1:9123#0)/test"); err == nil {
+		t.Errorf("invalid weight: got nil error")
+	}
+
+	var calls int
+	cluster, err := cdbpool.OpenCluster(srv.DSN("test", "balancer=roundrobin"),
+		cdbpool.WithMaxOpenConns(8),
+		cdbpool.WithNodePool(srv.Addr(), func(db *sql.DB) {
+			db.SetMaxOpenConns(4)
+		}),
+		cdbpool.WithConfig(func(cfg *cdbpool.Config) {
+			cfg.Hooks = &cdbpool.Hooks{
+				BeforeCall: func(ctx context.Context, req *cdbpool.CdbPoolRequest) {
+					calls++
+				},
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("open cluster: err=%v", err)
+	}
+	defer cluster.Close()
+
+	if n := cluster.Nodes()[0].Stats.MaxOpenConnections; n != 4 {
+		t.Errorf("max open conns: got %d, want 4", n)
+	}
+
+	srv.Handle("ori_delete", cdbpooltest.Delete(0))
+	if _, err = cluster.GetDB(context.Background(), "test", bigid.New(2), false).Exec("delete from test where 1=1"); err != nil {
+		t.Fatalf("delete: err=%v", err)
+	}
+	if calls != 1 {
+		t.Errorf("hook calls: got %d, want 1", calls)
+	}
+}
+
+// firstBalancer always picks the first candidate, counting the picks
+// without a route.
+type firstBalancer struct {
+	unrouted int32
+}
+
+func (b *firstBalancer) Pick(route *cdbpool.RouteInfo, nodes []*cdbpool.Node) *cdbpool.Node {
+	if route == nil {
+		atomic.AddInt32(&b.unrouted, 1)
+	}
+	return nodes[0]
+}
+
+func TestHedgedQuery(t *testing.T) {
+	slow, fast := cdbpooltest.NewServer(), cdbpooltest.NewServer()
+	defer slow.Close()
+	defer fast.Close()
+
+	slow.Handle("ori_select", cdbpooltest.Delay(500*time.Millisecond, cdbpooltest.Select(cdbpooltest.Records(
+		[]string{"value"}, []interface{}{"slow"},
+	))))
+	fast.Handle("ori_select", cdbpooltest.Select(cdbpooltest.Records(
+		[]string{"value"}, []interface{}{"fast"},
+	)))
+
+	balancer := &firstBalancer{}
+	cluster, err := cdbpool.OpenCluster(fmt.Sprintf("tcp(%s,%s)/test", slow.Addr(), fast.Addr()),
+		cdbpool.WithBalancer(balancer),
+	)
+	if err != nil {
+		t.Fatalf("open cluster: err=%v", err)
+	}
+	defer cluster.Close()
+
+	query := func(ctx context.Context) (string, time.Duration) {
+		start := time.Now()
+		rows, err := cluster.GetDB(ctx, "test", bigid.New(2), false).Query("select value from test where id=1")
+		if err != nil {
+			t.Fatalf("query: err=%v", err)
+		}
+		defer rows.Close()
+
+		var value string
+		for rows.Next() {
+			if err = rows.Scan(&value); err != nil {
+				t.Fatalf("scan: err=%v", err)
+			}
+		}
+		if err = rows.Err(); err != nil {
+			t.Fatalf("rows: err=%v", err)
+		}
+		return value, time.Since(start)
+	}
+
+	if value, elapsed := query(cdbpool.WithHedging(context.Background(), 20*time.Millisecond)); value != "fast" || elapsed > 400*time.Millisecond {
+		t.Errorf("hedged query: got %q after %v, want \"fast\"", value, elapsed)
+	}
+
+	if value, _ := query(context.Background()); value != "slow" {
+		t.Errorf("query without hedging: got %q, want \"slow\"", value)
+	}
+
+	var (
+		ctx   = cdbpool.WithHedging(context.Background(), 20*time.Millisecond)
+		start = time.Now()
+		value string
+	)
+	err = cluster.GetDB(ctx, "test", bigid.New(2), false).
+		QueryRow("/*+ cdbpool: bigid=2 */ select value from test where id=1").
+		Scan(&value)
+	if elapsed := time.Since(start); err != nil || value != "fast" || elapsed > 400*time.Millisecond {
+		t.Errorf("hedged query row: got %q, err=%v after %v, want \"fast\"", value, err, elapsed)
+	}
+	if n := atomic.LoadInt32(&balancer.unrouted); n != 0 {
+		t.Errorf("hedged reads: %d picks without a route", n)
+	}
+
+	fast.ResetRequests()
+	db := cluster.GetDB(cdbpool.WithHedging(context.Background(), time.Millisecond), "test", bigid.New(2), false)
+	slow.Handle("ori_delete", cdbpooltest.Delay(50*time.Millisecond, cdbpooltest.Delete(1)))
+	if _, err = db.Exec("delete from test where id=1"); err != nil {
+		t.Fatalf("delete: err=%v", err)
+	}
+	if n := len(fast.Requests()); n != 0 {
+		t.Errorf("write was hedged: %d requests on the second node", n)
+	}
+
+	// The error of the last reply is returned if both nodes fail.
+	slow.Handle("ori_select", cdbpooltest.Delay(50*time.Millisecond, cdbpooltest.MysqlError(1146, "no such table")))
+	fast.Handle("ori_select", cdbpooltest.MysqlError(1146, "no such table"))
+	if _, err = db.Query("select value from test where id=1"); err == nil {
+		t.Errorf("hedged query on failing nodes: got nil error")
+	}
+}
+
+func TestQueryMulti(t *testing.T) {
+	srv.Handle("ori_select", func(sess *cdbpooltest.Session, req *cdbpool.CdbPoolRequest) (*cdbpool.CdbPoolResponse, error) {
+		vsid := bigid.GetVSId(req.GetBigid())
+		if vsid == 4 {
+			return cdbpooltest.Error(cdbpool.ResultCode_RC_DB_MYSQL_QUERY, "shard down")(sess, req)
+		}
+		return cdbpooltest.Select(cdbpooltest.Records(
+			[]string{"id", "vsid"},
+			[]interface{}{int64(vsid * 10), int64(vsid)},
+			[]interface{}{int64(vsid*10 + 5), int64(vsid)},
+		))(sess, req)
+	})
+
+	cluster, err := cdbpool.OpenCluster(srv.DSN("test"))
+	if err != nil {
+		t.Fatalf("open cluster: err=%v", err)
+	}
+	defer cluster.Close()
+
+	bigIds := []uint64{bigid.New(1), bigid.New(3), bigid.New(2), bigid.New(1)}
+	rows, err := cdbpool.QueryMulti(context.Background(), cluster, "test", bigIds, "select id, vsid from test where 1=1", nil,
+		cdbpool.MultiOrderBy("id", true),
+		cdbpool.MultiLimit(4),
+		cdbpool.MultiConcurrency(2),
+	)
+	if err != nil {
+		t.Fatalf("query multi: err=%v", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id, vsid int64
+		if err = rows.Scan(&id, &vsid); err != nil {
+			t.Fatalf("scan: err=%v", err)
+		}
+		ids = append(ids, id)
+	}
+	if fmt.Sprint(ids) != "[35 30 25 20]" {
+		t.Errorf("merged ids: got %v, want [35 30 25 20]", ids)
+	}
+
+	_, err = cdbpool.QueryMulti(context.Background(), cluster, "test", append(bigIds, bigid.New(4)), "select id, vsid from test where 1=1", nil)
+	if errs, ok := err.(cdbpool.ShardErrors); !ok || len(errs) != 1 || errs[4] == nil {
+		t.Errorf("failed shard: got err %v, want ShardErrors for vsid 4", err)
+	}
+}
+
+func TestClusterServerStats(t *testing.T) {
+	srv2 := cdbpooltest.NewServer()
+	defer srv2.Close()
+
+	srv.Handle("stats", cdbpooltest.Error(cdbpool.ResultCode_RC_INTERNAL_ERROR, "stats failed"))
+	srv2.Handle("stats", cdbpooltest.Stats(map[string]interface{}{"qps": 10, "version": "1.2"}))
+
+	cluster, err := cdbpool.OpenCluster(fmt.Sprintf("tcp(%s,%s)/test", srv.Addr(), srv2.Addr()))
+	if err != nil {
+		t.Fatalf("open cluster: err=%v", err)
+	}
+	defer cluster.Close()
+
+	stats, err := cluster.ServerStats(context.Background())
+
+	errs, ok := err.(cdbpool.NodeErrors)
+	if !ok || len(errs) != 1 || errs[srv.Addr()] == nil {
+		t.Errorf("got err %v, want a NodeErrors for %v", err, srv.Addr())
+	}
+
+	s := stats[srv2.Addr()]
+	if len(stats) != 1 || s == nil {
+		t.Fatalf("got stats %v, want the stats of %v", stats, srv2.Addr())
+	}
+	if s.Addr != srv2.Addr() || s.Stats["qps"] != int64(10) || s.Stats["version"] != "1.2" {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+}
+
+func TestClusterReloadMysql(t *testing.T) {
+	srv2 := cdbpooltest.NewServer()
+	defer srv2.Close()
+
+	srv.Handle("reload_mysql", cdbpooltest.OK())
+	srv2.Handle("reload_mysql", cdbpooltest.Error(cdbpool.ResultCode_RC_INTERNAL_ERROR, "reload failed"))
+
+	cluster, err := cdbpool.OpenCluster(fmt.Sprintf("tcp(%s,%s)/test", srv.Addr(), srv2.Addr()))
+	if err != nil {
+		t.Fatalf("open cluster: err=%v", err)
+	}
+	defer cluster.Close()
+
+	srv.ResetRequests()
+	report := cluster.ReloadMysql(context.Background(), cdbpool.ReloadSpec{Reload: "all"})
+
+	if report.DryRun || len(report.Results) != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	for i, result := range report.Results {
+		if i > 0 && report.Results[i-1].Addr >= result.Addr {
+			t.Errorf("results not sorted by address: %+v", report.Results)
+		}
+		if wantErr := result.Addr == srv2.Addr(); (result.Err != nil) != wantErr {
+			t.Errorf("node %v: got err %v, want failure %v", result.Addr, result.Err, wantErr)
+		}
+	}
+
+	errs, ok := report.Err().(cdbpool.NodeErrors)
+	if !ok || len(errs) != 1 || errs[srv2.Addr()] == nil {
+		t.Errorf("got err %v, want a NodeErrors for %v", report.Err(), srv2.Addr())
+	}
+
+	req := srv.LastRequest()
+	if req == nil || req.Command != "reload_mysql" || req.GetReloadMysqlReq().GetReload() != "all" {
+		t.Errorf("unexpected request: %v", req)
+	}
+
+	srv.ResetRequests()
+	srv2.ResetRequests()
+	report = cluster.ReloadMysql(context.Background(), cdbpool.ReloadSpec{Reload: "all", DryRun: true})
+
+	if !report.DryRun || len(report.Results) != 2 || report.Err() != nil {
+		t.Errorf("dry run: got %+v, err %v", report, report.Err())
+	}
+	if n := len(srv.Requests()) + len(srv2.Requests()); n != 0 {
+		t.Errorf("dry run sent %d requests, want none", n)
+	}
+}
@@ -0,0 +1,128 @@
+// Command cdbpool-admin runs administrative commands against every node
+// of a cdbpool cluster.
+//
+//	cdbpool-admin -dsn 'tcp(10.0.0.1:9123,10.0.0.2:9123)/orders' reload [-dry-run] [-spec spec]
+//	cdbpool-admin -dsn 'tcp(10.0.0.1:9123,10.0.0.2:9123)/orders' stats
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/stn81/cdbpool"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s -dsn <dsn> [-timeout <duration>] <reload|stats> [flags]\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	os.Exit(run())
+}
+
+// run runs the command and returns the exit code, so that the deferred
+// calls run before the process exits.
+func run() int {
+	var (
+		dsn     = flag.String("dsn", "", "cluster dsn, e.g. tcp(10.0.0.1:9123,10.0.0.2:9123)/orders")
+		timeout = flag.Duration("timeout", 30*time.Second, "overall timeout")
+	)
+	flag.Usage = usage
+	flag.Parse()
+
+	if *dsn == "" || flag.NArg() == 0 {
+		usage()
+		return 2
+	}
+
+	cluster, err := cdbpool.OpenCluster(*dsn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open cluster: %v\n", err)
+		return 2
+	}
+	defer cluster.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	switch cmd, args := flag.Arg(0), flag.Args()[1:]; cmd {
+	case "reload":
+		err = reload(ctx, cluster, args)
+	case "stats":
+		err = stats(ctx, cluster, args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %v\n", cmd)
+		usage()
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func reload(ctx context.Context, cluster *cdbpool.Cluster, args []string) error {
+	var (
+		fs     = flag.NewFlagSet("reload", flag.ContinueOnError)
+		dryRun = fs.Bool("dry-run", false, "only ping the nodes")
+		spec   = fs.String("spec", "", "reload spec sent to the servers")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	report := cluster.ReloadMysql(ctx, cdbpool.ReloadSpec{
+		Reload: *spec,
+		DryRun: *dryRun,
+	})
+
+	for _, result := range report.Results {
+		status := "ok"
+		if result.Err != nil {
+			status = result.Err.Error()
+		}
+		fmt.Printf("%-24s %-10v %s\n", result.Addr, result.Elapsed.Round(time.Millisecond), status)
+	}
+	return report.Err()
+}
+
+func stats(ctx context.Context, cluster *cdbpool.Cluster, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	stats, err := cluster.ServerStats(ctx)
+
+	addrs := make([]string, 0, len(stats))
+	for addr := range stats {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	for _, addr := range addrs {
+		s := stats[addr]
+		fmt.Printf("[%s]\n", addr)
+
+		keys := make([]string, 0, len(s.Stats))
+		for key := range s.Stats {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			fmt.Printf("%s = %v\n", key, s.Stats[key])
+		}
+		if s.StatsStr != "" {
+			fmt.Println(s.StatsStr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stn81/bigid"
+	"github.com/stn81/cdbpool"
+	"github.com/stn81/cdbpool/cdbpooltest"
+	"github.com/stn81/kate/utils"
+	"github.com/stn81/log"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	keyTx = "tx"
+
+	errnoDupEntry        = 1062
+	errnoNoSuchTable     = 1146
+	errnoLockWaitTimeout = 1205
+	errnoUnknownError    = 1105
+)
+
+// querier is implemented by *sql.DB and *sql.Tx.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// sessionTx is the transaction opened by a client connection.
+type sessionTx struct {
+	*sql.Tx
+	file string
+}
+
+type backend struct {
+	dir       string
+	shardSpan int32
+	schema    string
+	timeout   time.Duration
+	mu        sync.Mutex
+	dbs       map[string]*sql.DB
+}
+
+func newBackend(dir string, shardSpan int, schema string, timeout time.Duration) *backend {
+	return &backend{
+		dir:       dir,
+		shardSpan: int32(shardSpan),
+		schema:    schema,
+		timeout:   timeout,
+		dbs:       make(map[string]*sql.DB),
+	}
+}
+
+func (b *backend) register(srv *cdbpooltest.Server) {
+	srv.Handle("ori_select", b.oriSelect)
+	srv.Handle("ori_insert", b.oriInsert)
+	srv.Handle("ori_update", b.oriUpdate)
+	srv.Handle("ori_delete", b.oriDelete)
+	srv.Handle("ori_show", b.oriShow)
+	srv.Handle("mulinsert", b.mulInsert)
+	srv.Handle("transfer", b.transfer)
+	srv.Handle("stats", b.stats)
+	srv.OnSessionClose(b.rollback)
+}
+
+func (b *backend) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, db := range b.dbs {
+		db.Close()
+	}
+}
+
+// file returns the sqlite file storing dbName for the vsid of bigId.
+func (b *backend) file(dbName string, bigId uint64) (string, error) {
+	if dbName == "" || strings.ContainsAny(dbName, `/\`) || strings.Contains(dbName, "..") {
+		return "", fmt.Errorf("invalid database name: %q", dbName)
+	}
+
+	vsid := utils.GetInt32(bigid.GetVSId(bigId))
+	return filepath.Join(b.dir, fmt.Sprintf("%s_%d.db", dbName, vsid/b.shardSpan)), nil
+}
+
+func (b *backend) open(file string) (*sql.DB, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if db, ok := b.dbs[file]; ok {
+		return db, nil
+	}
+
+	// Sessions get their own connections, and their transactions hold the
+	// sqlite write lock from begin: other writers wait for it up to the busy
+	// timeout, while readers see the last commit thanks to the wal journal.
+	db, err := sql.Open("sqlite", "file:"+file+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(wal)&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+
+	if b.schema != "" {
+		if _, err = db.Exec(b.schema); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("schema: %v", err)
+		}
+	}
+
+	b.dbs[file] = db
+	return db, nil
+}
+
+// querier returns the session transaction if any, or the database of the
+// request otherwise.
+func (b *backend) querier(sess *cdbpooltest.Session, dbName string, bigId uint64) (querier, error) {
+	file, err := b.file(dbName, bigId)
+	if err != nil {
+		return nil, err
+	}
+
+	if tx, ok := sess.Get(keyTx).(*sessionTx); ok {
+		if tx.file != file {
+			return nil, fmt.Errorf("transaction started on %s, request for %s", filepath.Base(tx.file), filepath.Base(file))
+		}
+		return tx, nil
+	}
+	return b.open(file)
+}
+
+func (b *backend) oriSelect(sess *cdbpooltest.Session, req *cdbpool.CdbPoolRequest) (*cdbpool.CdbPoolResponse, error) {
+	r := req.GetOriSelectReq()
+
+	q := fmt.Sprintf("select %s from %s", r.Columns, r.Table)
+	if r.ComplexFilter != "" {
+		q += " where " + r.ComplexFilter
+	}
+	if r.Orderby != "" {
+		q += " order by " + r.Orderby
+	}
+	if r.Limit != "" {
+		q += " limit " + r.Limit
+	}
+
+	records, err := b.query(sess, req, r.Dbname, q)
+	if err != nil {
+		return dbError(req, r.Dbname, q, err), nil
+	}
+
+	return &cdbpool.CdbPoolResponse{
+		Resp: &cdbpool.CdbPoolResponse_SelectResp{
+			SelectResp: &cdbpool.SelectResponse{Records: records},
+		},
+	}, nil
+}
+
+func (b *backend) oriShow(sess *cdbpooltest.Session, req *cdbpool.CdbPoolRequest) (*cdbpool.CdbPoolResponse, error) {
+	var (
+		r      = req.GetOriShowReq()
+		fields = strings.Fields(r.Content)
+		q      string
+	)
+
+	switch {
+	case len(fields) == 2 && strings.EqualFold(fields[1], "tables"):
+		q = fmt.Sprintf("select name as Tables_in_%s from sqlite_master where type = 'table' order by name", r.Dbname)
+	case len(fields) == 4 && strings.EqualFold(fields[1], "create") && strings.EqualFold(fields[2], "table"):
+		q = fmt.Sprintf("select name as `Table`, sql as `Create Table` from sqlite_master where type = 'table' and name = '%s'", strings.Trim(fields[3], "`"))
+	default:
+		return &cdbpool.CdbPoolResponse{
+			Error:  int32(cdbpool.ResultCode_RC_BAD_COMMAND),
+			ErrMsg: fmt.Sprintf("show statement not supported: %v", r.Content),
+		}, nil
+	}
+
+	records, err := b.query(sess, req, r.Dbname, q)
+	if err != nil {
+		return dbError(req, r.Dbname, r.Content, err), nil
+	}
+
+	return &cdbpool.CdbPoolResponse{
+		Resp: &cdbpool.CdbPoolResponse_OriShowResp{
+			OriShowResp: &cdbpool.OriShowResponse{Records: records},
+		},
+	}, nil
+}
+
+func (b *backend) oriInsert(sess *cdbpooltest.Session, req *cdbpool.CdbPoolRequest) (*cdbpool.CdbPoolResponse, error) {
+	r := req.GetOriInsertReq()
+	q := fmt.Sprintf("insert into %s%s values %s", r.Table, r.Columns, r.Values)
+
+	result, err := b.exec(sess, req, r.Dbname, q)
+	if err != nil {
+		return dbError(req, r.Dbname, q, err), nil
+	}
+	return insertResponse(result), nil
+}
+
+func (b *backend) mulInsert(sess *cdbpooltest.Session, req *cdbpool.CdbPoolRequest) (*cdbpool.CdbPoolResponse, error) {
+	var (
+		r            = req.GetMulinsertReq()
+		placeholders = "(" + strings.TrimSuffix(strings.Repeat("?, ", len(r.Columns)), ", ") + ")"
+		tuples       = make([]string, len(r.Valuelists))
+		args         = make([]interface{}, 0, len(r.Columns)*len(r.Valuelists))
+	)
+
+	for i, vl := range r.Valuelists {
+		tuples[i] = placeholders
+		for _, v := range vl.Values {
+			args = append(args, v)
+		}
+	}
+
+	q := fmt.Sprintf("insert into %s(%s) values %s", r.Table, strings.Join(r.Columns, ", "), strings.Join(tuples, ", "))
+
+	result, err := b.exec(sess, req, r.Dbname, q, args...)
+	if err != nil {
+		return dbError(req, r.Dbname, q, err), nil
+	}
+	return insertResponse(result), nil
+}
+
+func (b *backend) oriUpdate(sess *cdbpooltest.Session, req *cdbpool.CdbPoolRequest) (*cdbpool.CdbPoolResponse, error) {
+	r := req.GetOriUpdateReq()
+	q := fmt.Sprintf("update %s set %s where %s", r.Table, r.Sets, r.ComplexFilter)
+
+	result, err := b.exec(sess, req, r.Dbname, q)
+	if err != nil {
+		return dbError(req, r.Dbname, q, err), nil
+	}
+
+	affected, _ := result.RowsAffected()
+	return &cdbpool.CdbPoolResponse{
+		Resp: &cdbpool.CdbPoolResponse_UpdateResp{
+			UpdateResp: &cdbpool.UpdateResponse{
+				Bigid:      req.Bigid,
+				AffectRows: uint32(affected),
+			},
+		},
+	}, nil
+}
+
+func (b *backend) oriDelete(sess *cdbpooltest.Session, req *cdbpool.CdbPoolRequest) (*cdbpool.CdbPoolResponse, error) {
+	r := req.GetOriDeleteReq()
+	q := fmt.Sprintf("delete from %s where %s", r.Table, r.ComplexFilter)
+
+	result, err := b.exec(sess, req, r.Dbname, q)
+	if err != nil {
+		return dbError(req, r.Dbname, q, err), nil
+	}
+
+	affected, _ := result.RowsAffected()
+	return &cdbpool.CdbPoolResponse{
+		Resp: &cdbpool.CdbPoolResponse_DeleteResp{
+			DeleteResp: &cdbpool.DeleteResponse{
+				Bigid:      req.Bigid,
+				AffectRows: uint32(affected),
+			},
+		},
+	}, nil
+}
+
+func (b *backend) transfer(sess *cdbpooltest.Session, req *cdbpool.CdbPoolRequest) (*cdbpool.CdbPoolResponse, error) {
+	var (
+		r      = req.GetTransferReq()
+		tx, ok = sess.Get(keyTx).(*sessionTx)
+		err    error
+	)
+
+	switch r.Command {
+	case "begin":
+		if ok {
+			err = fmt.Errorf("transaction already started")
+			break
+		}
+
+		var (
+			db   *sql.DB
+			file string
+		)
+		if file, err = b.file(r.Dbname, req.Bigid); err != nil {
+			break
+		}
+		if db, err = b.open(file); err != nil {
+			break
+		}
+
+		// The transaction outlives the request, so it can not be bound to a
+		// request context: waiting for the write lock is bounded by the
+		// busy timeout instead.
+		tx = &sessionTx{file: file}
+		if tx.Tx, err = db.Begin(); err == nil {
+			sess.Set(keyTx, tx)
+		}
+	case "commit", "rollback":
+		if !ok {
+			err = fmt.Errorf("no transaction started")
+			break
+		}
+
+		sess.Set(keyTx, nil)
+		if r.Command == "commit" {
+			err = tx.Commit()
+		} else {
+			err = tx.Rollback()
+		}
+	default:
+		err = fmt.Errorf("unknown transfer command: %v", r.Command)
+	}
+
+	if err != nil {
+		return dbError(req, r.Dbname, r.Command, err), nil
+	}
+
+	return &cdbpool.CdbPoolResponse{
+		Resp: &cdbpool.CdbPoolResponse_TransferResp{
+			TransferResp: &cdbpool.TransferResponse{Bigid: req.Bigid},
+		},
+	}, nil
+}
+
+// rollback aborts the transaction left open by a closed session.
+func (b *backend) rollback(sess *cdbpooltest.Session) {
+	if tx, ok := sess.Get(keyTx).(*sessionTx); ok {
+		log.Info(mctx, "rollback transaction of closed session", "session_id", sess.ID(), "file", tx.file)
+		tx.Rollback()
+	}
+}
+
+func (b *backend) stats(sess *cdbpooltest.Session, req *cdbpool.CdbPoolRequest) (*cdbpool.CdbPoolResponse, error) {
+	b.mu.Lock()
+	files := len(b.dbs)
+	b.mu.Unlock()
+
+	records := cdbpooltest.Records(
+		[]string{"files", "shard_span", "time"},
+		[]interface{}{files, b.shardSpan, time.Now()},
+	)
+
+	return &cdbpool.CdbPoolResponse{
+		Resp: &cdbpool.CdbPoolResponse_StatsResp{
+			StatsResp: &cdbpool.ServerStatsResponse{Stats: records[0].Units},
+		},
+	}, nil
+}
+
+func (b *backend) query(sess *cdbpooltest.Session, req *cdbpool.CdbPoolRequest, dbName, q string) ([]*cdbpool.StoreRecord, error) {
+	db, err := b.querier(sess, dbName, req.Bigid)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(mctx, b.timeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, toSQLite(q))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		records []*cdbpool.StoreRecord
+		values  = make([]interface{}, len(columns))
+		dest    = make([]interface{}, len(columns))
+	)
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		row := make([]interface{}, len(values))
+		copy(row, values)
+		records = append(records, cdbpooltest.Records(columns, row)...)
+	}
+	return records, rows.Err()
+}
+
+func (b *backend) exec(sess *cdbpooltest.Session, req *cdbpool.CdbPoolRequest, dbName, q string, args ...interface{}) (sql.Result, error) {
+	db, err := b.querier(sess, dbName, req.Bigid)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(mctx, b.timeout)
+	defer cancel()
+
+	return db.ExecContext(ctx, toSQLite(q), args...)
+}
+
+func insertResponse(result sql.Result) *cdbpool.CdbPoolResponse {
+	var (
+		lastInsertId, _ = result.LastInsertId()
+		affected, _     = result.RowsAffected()
+	)
+
+	return &cdbpool.CdbPoolResponse{
+		Resp: &cdbpool.CdbPoolResponse_InsertResp{
+			InsertResp: &cdbpool.InsertResponse{
+				LastInsertid: uint64(lastInsertId),
+				AffectRows:   uint32(affected),
+			},
+		},
+	}
+}
+
+// dbError reports err as a mysql query error, mapping the sqlite errors
+// callers commonly check to their mysql errno.
+func dbError(req *cdbpool.CdbPoolRequest, dbName, q string, err error) *cdbpool.CdbPoolResponse {
+	errno := uint32(errnoUnknownError)
+	switch msg := err.Error(); {
+	case strings.Contains(msg, "UNIQUE constraint failed"):
+		errno = errnoDupEntry
+	case strings.Contains(msg, "no such table"):
+		errno = errnoNoSuchTable
+	case strings.Contains(msg, "database is locked"), errors.Is(err, context.DeadlineExceeded):
+		errno = errnoLockWaitTimeout
+	}
+
+	log.Error(mctx, "query failed", "logid", req.Logid, "sql", q, "error", err)
+
+	return &cdbpool.CdbPoolResponse{
+		Error:  int32(cdbpool.ResultCode_RC_DB_MYSQL_QUERY),
+		ErrMsg: err.Error(),
+		SqlInfo: &cdbpool.MysqlInfo{
+			Sql:        q,
+			Dbname:     dbName,
+			Vsid:       utils.GetInt32(bigid.GetVSId(req.Bigid)),
+			MysqlErrno: errno,
+		},
+	}
+}
+
+// toSQLite rewrites the mysql string literals, which may be double quoted
+// and use backslash escapes, into sqlite single quoted literals.
+func toSQLite(q string) string {
+	var (
+		buf   strings.Builder
+		quote byte
+	)
+
+	writeChar := func(c byte) {
+		if c == '\'' {
+			buf.WriteString("''")
+		} else {
+			buf.WriteByte(c)
+		}
+	}
+
+	for i := 0; i < len(q); i++ {
+		c := q[i]
+
+		switch {
+		case quote == 0:
+			switch c {
+			case '\'', '"':
+				quote = c
+				buf.WriteByte('\'')
+			case '`':
+				j := strings.IndexByte(q[i+1:], '`')
+				if j < 0 {
+					buf.WriteString(q[i:])
+					return buf.String()
+				}
+				buf.WriteString(q[i : i+j+2])
+				i += j + 1
+			default:
+				buf.WriteByte(c)
+			}
+		case c == '\\' && i+1 < len(q):
+			i++
+			switch q[i] {
+			case '0':
+				buf.WriteByte(0)
+			case 'n':
+				buf.WriteByte('\n')
+			case 'r':
+				buf.WriteByte('\r')
+			case 'Z':
+				buf.WriteByte('\x1a')
+			default:
+				writeChar(q[i])
+			}
+		case c == quote:
+			if i+1 < len(q) && q[i+1] == quote {
+				i++
+				writeChar(c)
+				continue
+			}
+			quote = 0
+			buf.WriteByte('\'')
+		default:
+			writeChar(c)
+		}
+	}
+	return buf.String()
+}
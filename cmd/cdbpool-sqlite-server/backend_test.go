@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stn81/bigid"
+	"github.com/stn81/cdbpool"
+	"github.com/stn81/cdbpool/cdbpooltest"
+)
+
+func TestToSQLite(t *testing.T) {
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{`select 1`, `select 1`},
+		{`name = 'foo'`, `name = 'foo'`},
+		{`name = "foo"`, `name = 'foo'`},
+		{`name = "it's"`, `name = 'it''s'`},
+		{`name = 'it\'s'`, `name = 'it''s'`},
+		{`name = 'it''s'`, `name = 'it''s'`},
+		{`name = "say ""hi"""`, `name = 'say "hi"'`},
+		{`name = 'a\nb\\c'`, "name = 'a\nb\\c'"},
+		{`name = 'a\0b'`, "name = 'a\x00b'"},
+		{"select `it's` from t", "select `it's` from t"},
+		{"select `a", "select `a"},
+	}
+
+	for _, test := range tests {
+		if got := toSQLite(test.q); got != test.want {
+			t.Errorf("toSQLite(%q): got %q, want %q", test.q, got, test.want)
+		}
+	}
+}
+
+func TestBackendFile(t *testing.T) {
+	b := newBackend("data", 4, "", time.Second)
+
+	file, err := b.file("users", bigid.New(5))
+	if err != nil || file != "data/users_1.db" {
+		t.Errorf("file(users, 5): got %q, err=%v", file, err)
+	}
+
+	for _, dbName := range []string{"", "../users", "a/b", `a\b`, ".."} {
+		if _, err = b.file(dbName, 0); err == nil {
+			t.Errorf("file(%q): got no error", dbName)
+		}
+	}
+}
+
+func TestBackend(t *testing.T) {
+	srv := cdbpooltest.NewServer()
+	defer srv.Close()
+
+	b := newBackend(t.TempDir(), 1, "create table if not exists users (id integer primary key, name text unique)", 5*time.Second)
+	b.register(srv)
+	defer b.close()
+
+	db, err := sql.Open("cdbpool", srv.DSN("test"))
+	if err != nil {
+		t.Fatalf("sql.Open() error: %v", err)
+	}
+	defer db.Close()
+
+	ctx := cdbpool.SetRoute(context.Background(), "test", bigid.New(1), false)
+
+	if _, err = db.ExecContext(ctx, "insert into users(id, name) values(?, ?)", 1, "it's"); err != nil {
+		t.Fatalf("insert error: %v", err)
+	}
+
+	_, err = db.ExecContext(ctx, "insert into users(id, name) values(?, ?)", 2, "it's")
+	var dbErr *cdbpool.DBError
+	if !errors.As(err, &dbErr) || dbErr.GetMysqlErrno() != errnoDupEntry {
+		t.Errorf("duplicate insert: got %v, want mysql errno %v", err, errnoDupEntry)
+	}
+
+	// A transaction held by a session must not block the other sessions.
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("begin error: %v", err)
+	}
+	if _, err = tx.ExecContext(ctx, "update users set name = ? where id = ?", "bar", 1); err != nil {
+		t.Fatalf("update in transaction error: %v", err)
+	}
+
+	var name string
+	if err = db.QueryRowContext(ctx, "select name from users where id = ?", 1).Scan(&name); err != nil || name != "it's" {
+		t.Errorf("select during transaction: got %q, err=%v, want the last commit", name, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		t.Fatalf("commit error: %v", err)
+	}
+	if err = db.QueryRowContext(ctx, "select name from users where id = ?", 1).Scan(&name); err != nil || name != "bar" {
+		t.Errorf("select after commit: got %q, err=%v, want \"bar\"", name, err)
+	}
+
+	ctx = cdbpool.SetRoute(context.Background(), "../test", bigid.New(1), false)
+	if _, err = db.ExecContext(ctx, "insert into users(id, name) values(?, ?)", 3, "baz"); err == nil {
+		t.Errorf("insert into ../test: got no error")
+	}
+}
@@ -0,0 +1,77 @@
+// Command cdbpool-sqlite-server is a reference cdbpool server storing its
+// data in SQLite files, for local development and integration tests.
+//
+// Each database name and vsid range is stored in its own file under -dir,
+// named <dbname>_<shard>.db where shard is the vsid of the request bigid
+// divided by -shard-span. The -schema script is run whenever a file is opened.
+//
+// Queries taking longer than -query-timeout fail like a mysql lock wait
+// timeout.
+//
+//	cdbpool-sqlite-server -addr 127.0.0.1:9123 -dir ./data -shard-span 1024 -schema schema.sql
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/stn81/cdbpool/cdbpooltest"
+	"github.com/stn81/log"
+)
+
+var (
+	mctx = log.SetContext(context.Background(), "module", "cdbpool-sqlite-server")
+)
+
+func main() {
+	var (
+		addr      = flag.String("addr", "127.0.0.1:9123", "listen address")
+		dir       = flag.String("dir", ".", "directory of the sqlite files")
+		shardSpan = flag.Int("shard-span", 1, "number of vsids stored in each sqlite file")
+		schema    = flag.String("schema", "", "sql script run whenever a sqlite file is opened, e.g. create table if not exists")
+		timeout   = flag.Duration("query-timeout", 10*time.Second, "maximum duration of a query")
+		script    []byte
+		err       error
+	)
+	flag.Parse()
+
+	if *shardSpan <= 0 {
+		log.Fatal(mctx, "invalid shard span", "shard_span", *shardSpan)
+	}
+
+	if *timeout <= 0 {
+		log.Fatal(mctx, "invalid query timeout", "query_timeout", *timeout)
+	}
+
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		log.Fatal(mctx, "failed to create data dir", "dir", *dir, "error", err)
+	}
+
+	if *schema != "" {
+		if script, err = os.ReadFile(*schema); err != nil {
+			log.Fatal(mctx, "failed to read schema", "schema", *schema, "error", err)
+		}
+	}
+
+	srv, err := cdbpooltest.Listen(*addr)
+	if err != nil {
+		log.Fatal(mctx, "failed to listen", "addr", *addr, "error", err)
+	}
+
+	b := newBackend(*dir, *shardSpan, string(script), *timeout)
+	b.register(srv)
+
+	log.Info(mctx, "server started", "addr", srv.Addr(), "dir", *dir, "shard_span", *shardSpan)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+
+	srv.Close()
+	b.close()
+	log.Info(mctx, "server stopped")
+}
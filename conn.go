@@ -5,19 +5,27 @@ import (
 	"database/sql/driver"
 	"fmt"
 	"sync/atomic"
+	"time"
 
-	"github.com/stn81/log"
 	"github.com/stn81/knet"
 )
 
 var nextConnId = uint64(0)
 
+var (
+	_ driver.ExecerContext     = (*Conn)(nil)
+	_ driver.QueryerContext    = (*Conn)(nil)
+	_ driver.NamedValueChecker = (*Conn)(nil)
+)
+
 type Conn struct {
 	knet.IoHandlerAdapter
 	*Config
 	id     uint64
 	client knet.Client
 	ctx    context.Context
+	mux    *muxPool    // pool of the multiplexed sessions, if any
+	shared knet.Client // shared session while a transaction pins the connection
 }
 
 func newConn() *Conn {
@@ -35,27 +43,62 @@ func (c *Conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, e
 		return nil, driver.ErrBadConn
 	}
 
-	if GetRoute(ctx) == nil {
-		if c.ctx == nil {
-			panic(ErrMissingRouteInfo)
-		}
+	// The route may also be given by a query hint, so that a missing route
+	// is only reported when the statement is run.
+	if GetRoute(ctx) == nil && c.ctx != nil {
 		ctx = c.ctx
 	}
 
-	return newStmt(ctx, c, query), nil
+	stmt, err := newStmt(ctx, c, query)
+	if err != nil {
+		return nil, c.checkSQLError(err)
+	}
+	return stmt, nil
+}
+
+// ExecContext implements driver.ExecerContext, running query without the
+// prepare and close bookkeeping of database/sql.
+func (c *Conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.client == nil || !c.client.IsConnected() {
+		return nil, driver.ErrBadConn
+	}
+
+	stmt, err := newStmt(ctx, c, query)
+	if err != nil {
+		return nil, c.checkSQLError(err)
+	}
+	return stmt.ExecContext(ctx, args)
+}
+
+// QueryContext implements driver.QueryerContext, running query without the
+// prepare and close bookkeeping of database/sql.
+func (c *Conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if c.client == nil || !c.client.IsConnected() {
+		return nil, driver.ErrBadConn
+	}
+
+	stmt, err := newStmt(ctx, c, query)
+	if err != nil {
+		return nil, c.checkSQLError(err)
+	}
+	return stmt.QueryContext(ctx, args)
 }
 
 func (c *Conn) Close() error {
 	if Debug {
-		log.Debug(mctx, "close connection", "conn_id", c.id)
+		c.logger().Debug(mctx, "close connection", "conn_id", c.id)
 	}
 
-	if c.client != nil {
+	if c.mux != nil {
+		// closed in a transaction, which may still run on the session
+		c.unpin(false)
+		c.mux.release(c.client)
+	} else if c.client != nil {
 		c.client.Close()
 	}
 
 	if Debug {
-		log.Debug(mctx, "close connection end", "conn_id", c.id)
+		c.logger().Debug(mctx, "close connection end", "conn_id", c.id)
 	}
 	return nil
 }
@@ -67,7 +110,11 @@ func (c *Conn) Begin() (driver.Tx, error) {
 func (c *Conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
 	route := GetRoute(ctx)
 	if route == nil {
-		panic(ErrMissingRouteInfo)
+		return nil, c.checkSQLError(ErrMissingRouteInfo)
+	}
+
+	if err := c.pin(ctx); err != nil {
+		return nil, err
 	}
 
 	exr := &beginExecutor{
@@ -75,29 +122,67 @@ func (c *Conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, e
 		ctx:       ctx,
 		dbc:       c,
 	}
-	return exr.Run()
+
+	tx, err := exr.Run()
+	if err != nil {
+		c.unpin(err != driver.ErrBadConn)
+	}
+	return tx, err
 }
 
-func (c *Conn) OnIdle(session *knet.IoSession) error {
-	if session.GetIdleCount() > 2 {
-		return knet.ErrPeerDead
+// pin moves a multiplexed connection to a dedicated session for a
+// transaction, as the server binds transactions to sessions.
+func (c *Conn) pin(ctx context.Context) error {
+	if c.mux == nil || c.shared != nil {
+		return nil
 	}
 
-	pkt := newPingPacket()
-	session.Send(mctx, pkt)
+	client, err := c.mux.dedicated(ctx)
+	if err != nil {
+		return err
+	}
+	c.shared, c.client = c.client, client
 	return nil
 }
 
+// unpin moves a multiplexed connection back to its shared session after a
+// transaction. The dedicated session is reused only if the transaction is
+// known to be finished.
+func (c *Conn) unpin(reuse bool) {
+	if c.shared == nil {
+		return
+	}
+
+	c.mux.releaseDedicated(c.client, reuse)
+	c.client, c.shared = c.shared, nil
+}
+
+// checkSQLError panics with err if it is a validation error and the
+// connection was opened with panicOnInvalidSQL, which restores the
+// behavior of earlier releases.
+func (c *Conn) checkSQLError(err error) error {
+	if err != nil && c.PanicOnInvalidSQL && isInvalidSQL(err) {
+		panic(err)
+	}
+	return err
+}
+
+func (c *Conn) OnIdle(session *knet.IoSession) error {
+	return pingIdle(session)
+}
+
 func (c *Conn) OnError(session *knet.IoSession, err error) {
-	log.Error(mctx, "connection error", "conn_id", c.id, "server_addr", c.Addr, "error", err)
+	c.logger().Error(mctx, "connection error", "conn_id", c.id, "server_addr", c.Addr, "error", err)
 }
 
 func (c *Conn) OnDisconnected(session *knet.IoSession) {
-	log.Info(mctx, "disconnected", "conn_id", c.id, "server_addr", c.Addr)
+	c.logger().Info(mctx, "disconnected", "conn_id", c.id, "server_addr", c.Addr)
 }
 
 func (c *Conn) Ping(ctx context.Context) error {
-	pkt := newPingPacket()
+	// Pings are matched by ContextId like queries, which must be unique
+	// on multiplexed sessions.
+	pkt := newPacket(nextRequestId(), CmdPing, nil)
 	if _, err := c.client.Call(ctx, pkt); err != nil {
 		return driver.ErrBadConn
 	}
@@ -105,6 +190,27 @@ func (c *Conn) Ping(ctx context.Context) error {
 	return nil
 }
 
+// ServerStats returns the statistics of the server this connection is
+// attached to. Use (*sql.Conn).Raw to reach it from database/sql.
+func (c *Conn) ServerStats(ctx context.Context) (*ServerStats, error) {
+	exr := &statsExecutor{
+		ctx: ctx,
+		dbc: c,
+	}
+	return exr.Run()
+}
+
+// ReloadMysql asks the server this connection is attached to to reload
+// its mysql configuration.
+func (c *Conn) ReloadMysql(ctx context.Context, reload string) error {
+	exr := &reloadExecutor{
+		ctx:    ctx,
+		dbc:    c,
+		reload: reload,
+	}
+	return exr.Run()
+}
+
 func (c *Conn) call(ctx context.Context, req *CdbPoolRequest) (resp *CdbPoolResponse, err error) {
 	var (
 		seq     = nextRequestId()
@@ -114,8 +220,20 @@ func (c *Conn) call(ctx context.Context, req *CdbPoolRequest) (resp *CdbPoolResp
 		ok      bool
 	)
 
+	if c.Hooks != nil {
+		start := time.Now()
+		if c.Hooks.BeforeCall != nil {
+			c.Hooks.BeforeCall(ctx, req)
+		}
+		if c.Hooks.AfterCall != nil {
+			defer func() {
+				c.Hooks.AfterCall(ctx, req, resp, err, time.Since(start))
+			}()
+		}
+	}
+
 	if c.client == nil || !c.client.IsConnected() {
-		log.Error(ctx, "server not connected", "conn_id", c.id, "server_addr", c.Addr)
+		c.logger().Error(ctx, "server not connected", "conn_id", c.id, "server_addr", c.Addr)
 		return nil, driver.ErrBadConn
 	}
 
@@ -125,7 +243,7 @@ func (c *Conn) call(ctx context.Context, req *CdbPoolRequest) (resp *CdbPoolResp
 	pkt = newQueryPacket(seq, req)
 
 	if Debug {
-		log.Debug(ctx, "cdbpool conn.call begin",
+		c.logger().Debug(ctx, "cdbpool conn.call begin",
 			"conn_id", c.id,
 			"local_addr", session.LocalAddr(),
 			"remote_addr", session.RemoteAddr(),
@@ -134,7 +252,7 @@ func (c *Conn) call(ctx context.Context, req *CdbPoolRequest) (resp *CdbPoolResp
 	}
 
 	if reply, err = c.client.Call(ctx, pkt); err != nil {
-		log.Error(ctx, "cdbpool conn.call",
+		c.logger().Error(ctx, "cdbpool conn.call",
 			"conn_id", c.id,
 			"local_addr", session.LocalAddr(),
 			"server_addr", c.Addr,
@@ -145,7 +263,7 @@ func (c *Conn) call(ctx context.Context, req *CdbPoolRequest) (resp *CdbPoolResp
 	}
 
 	if Debug {
-		log.Debug(ctx, "cdbpool conn.call end",
+		c.logger().Debug(ctx, "cdbpool conn.call end",
 			"conn_id", c.id,
 			"local_addr", session.LocalAddr(),
 			"remote_addr", session.RemoteAddr(),
@@ -154,7 +272,7 @@ func (c *Conn) call(ctx context.Context, req *CdbPoolRequest) (resp *CdbPoolResp
 	}
 
 	if resp, ok = reply.(*Packet).Message.(*CdbPoolResponse); !ok {
-		log.Error(ctx, "cdbpool conn.call",
+		c.logger().Error(ctx, "cdbpool conn.call",
 			"conn_id", c.id,
 			"local_addr", session.LocalAddr(),
 			"server_addr", c.Addr,
@@ -0,0 +1,114 @@
+package cdbpool
+
+import (
+	"context"
+	"database/sql/driver"
+	"time"
+
+	"github.com/stn81/knet"
+)
+
+// Hooks are called around every request sent to the server.
+type Hooks struct {
+	BeforeCall func(ctx context.Context, req *CdbPoolRequest)
+	AfterCall  func(ctx context.Context, req *CdbPoolRequest, resp *CdbPoolResponse, err error, elapsed time.Duration)
+}
+
+type connector struct {
+	cfg *Config
+	mux *muxPool // shared sessions, nil unless cfg.Multiplex
+}
+
+// NewConnector returns a driver.Connector for sql.OpenDB, allowing to set
+// the fields of Config which can not be encoded in a DSN.
+func NewConnector(cfg *Config) (driver.Connector, error) {
+	cfg = cfg.Clone()
+	if cfg.Net == "" {
+		cfg.Net = defaultNet
+	}
+	if cfg.Addr == "" {
+		cfg.Addr = defaultAddr
+	}
+
+	c := &connector{cfg: cfg}
+	if cfg.Multiplex {
+		c.mux = newMuxPool(cfg)
+	}
+	return c, nil
+}
+
+// Connect implements driver.Connector.
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	dbc := newConn()
+	dbc.Config = c.cfg
+
+	if Debug {
+		dbc.logger().Debug(mctx, "connector.Connect()", "conn_id", dbc.id)
+	}
+
+	if c.mux != nil {
+		client, err := c.mux.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		dbc.client = client
+		dbc.mux = c.mux
+		return dbc, nil
+	}
+
+	if dbc.NewClient != nil {
+		dbc.client = dbc.NewClient(dbc.Config)
+	} else {
+		dbc.client = newTCPClient(dbc.Config)
+	}
+
+	dbc.client.SetProtocol(&Protocol{})
+	dbc.client.SetIoHandler(dbc)
+
+	if Debug {
+		dbc.logger().Debug(mctx, "dail to server begin", "conn_id", dbc.id, "remote_addr", dbc.Addr)
+	}
+
+	if err := dbc.client.Dial(dbc.Addr); err != nil {
+		dbc.client.Close()
+		return nil, err
+	}
+
+	if Debug {
+		dbc.logger().Debug(mctx, "dail to server end", "conn_id", dbc.id, "remote_addr", dbc.Addr)
+	}
+
+	return dbc, nil
+}
+
+// Close implements io.Closer, called by sql.DB.Close.
+func (c *connector) Close() error {
+	if c.mux != nil {
+		c.mux.close()
+	}
+	return nil
+}
+
+// Driver implements driver.Connector.
+func (c *connector) Driver() driver.Driver {
+	return &CdbPoolDriver{}
+}
+
+func newTCPClient(cfg *Config) knet.Client {
+	conf := knet.NewTCPClientConfig()
+	if cfg.Timeout > 0 {
+		conf.DialTimeout = cfg.Timeout
+	}
+	if cfg.ReadTimeout > 0 {
+		conf.Io.ReadTimeout = cfg.ReadTimeout
+	}
+	if cfg.WriteTimeout > 0 {
+		conf.Io.WriteTimeout = cfg.WriteTimeout
+	}
+
+	client := knet.NewTCPClient(mctx, conf)
+	if cfg.EnableCircuitBreaker {
+		client = knet.NewCircuitBreakerClient(client, getBreaker(cfg.Addr, cfg.BreakerSettings))
+	}
+	return client
+}
@@ -7,7 +7,9 @@ import (
 
 type DB struct {
 	*sql.DB
-	ctx context.Context
+	ctx     context.Context
+	cluster *Cluster // cluster of the node, nil if not from Cluster.GetDB
+	node    *Node
 }
 
 func (db *DB) GetContext() context.Context {
@@ -19,11 +21,34 @@ func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
 }
 
 func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	return db.DB.QueryContext(db.ctx, query, args...)
+	return db.QueryContext(db.ctx, query, args...)
+}
+
+// QueryContext runs query with the route of ctx, or the route of db if ctx
+// has none. SELECTs are hedged if ctx enables it, see WithHedging.
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx = db.hedge(db.withRoute(ctx), query)
+	return db.DB.QueryContext(ctx, query, args...)
 }
 
 func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
-	return db.DB.QueryRowContext(db.ctx, query, args...)
+	return db.QueryRowContext(db.ctx, query, args...)
+}
+
+// QueryRowContext is like QueryContext for a single row.
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx = db.hedge(db.withRoute(ctx), query)
+	return db.DB.QueryRowContext(ctx, query, args...)
+}
+
+// withRoute returns ctx with the route of db if it has none.
+func (db *DB) withRoute(ctx context.Context) context.Context {
+	if GetRoute(ctx) == nil {
+		if route := GetRoute(db.ctx); route != nil {
+			ctx = SetRoute(ctx, route.DBName, route.BigId, route.Offline)
+		}
+	}
+	return ctx
 }
 
 func (db *DB) Begin() (*sql.Tx, error) {
@@ -1,6 +1,14 @@
 package cdbpool
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidSQL     = errors.New("sql invalid")
+	ErrUnsupportedArg = errors.New("unsupported argument type")
+)
 
 type DBError struct {
 	ErrCode int32
@@ -49,3 +57,52 @@ func ErrCodeName(code int32) string {
 	}
 	return "unknown"
 }
+
+// SQLValidationError is returned when a statement is rejected by the driver
+// before being sent to the server. Err is ErrInvalidSQL or ErrSqlNotSupport,
+// so callers can test for them with errors.Is.
+type SQLValidationError struct {
+	Clause string // the offending clause, e.g. "where", "limit"
+	Detail string
+	Err    error
+}
+
+func (e *SQLValidationError) Error() string {
+	return fmt.Sprintf("%v: %s", e.Err, e.Detail)
+}
+
+func (e *SQLValidationError) Unwrap() error {
+	return e.Err
+}
+
+func newSQLValidationError(clause, detail string) *SQLValidationError {
+	return &SQLValidationError{
+		Clause: clause,
+		Detail: detail,
+		Err:    ErrInvalidSQL,
+	}
+}
+
+// isInvalidSQL reports whether err was caused by the statement or its
+// route rather than by the server or the connection.
+func isInvalidSQL(err error) bool {
+	return errors.Is(err, ErrInvalidSQL) ||
+		errors.Is(err, ErrSqlNotSupport) ||
+		errors.Is(err, ErrMissingRouteInfo)
+}
+
+// ArgError is returned when an argument can not be interpolated in the
+// query. Err is ErrUnsupportedArg, or the error of a driver.Valuer.
+type ArgError struct {
+	Ordinal int // position of the argument, starting at 1
+	Value   interface{}
+	Err     error
+}
+
+func (e *ArgError) Error() string {
+	return fmt.Sprintf("argument %d of type %T: %v", e.Ordinal, e.Value, e.Err)
+}
+
+func (e *ArgError) Unwrap() error {
+	return e.Err
+}
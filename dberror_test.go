@@ -0,0 +1,42 @@
+package cdbpool
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stn81/sqlparser"
+)
+
+func TestSQLValidationError(t *testing.T) {
+	exr := &selectExecutor{
+		RouteInfo: &RouteInfo{},
+		dbc:       &Conn{Config: &Config{}},
+		ast:       &sqlparser.Select{},
+	}
+
+	err := exr.parse()
+	if !errors.Is(err, ErrInvalidSQL) {
+		t.Fatalf("select without where: got %v, want ErrInvalidSQL", err)
+	}
+
+	var verr *SQLValidationError
+	if !errors.As(err, &verr) || verr.Clause != "where" {
+		t.Errorf("select without where: got %#v, want clause `where`", err)
+	}
+}
+
+func TestPanicOnInvalidSQL(t *testing.T) {
+	dbc := &Conn{Config: &Config{}}
+	if err := dbc.checkSQLError(ErrMissingRouteInfo); err != ErrMissingRouteInfo {
+		t.Errorf("checkSQLError(): got %v, want ErrMissingRouteInfo", err)
+	}
+
+	defer func() {
+		if r := recover(); r != ErrMissingRouteInfo {
+			t.Errorf("panicOnInvalidSQL: got panic %v, want ErrMissingRouteInfo", r)
+		}
+	}()
+
+	dbc.PanicOnInvalidSQL = true
+	dbc.checkSQLError(ErrMissingRouteInfo)
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 
 	"github.com/stn81/bigid"
-	"github.com/stn81/log"
 	"github.com/stn81/sqlparser"
 	"github.com/stn81/kate/utils"
 )
@@ -16,6 +15,7 @@ type deleteExecutor struct {
 	ctx     context.Context
 	dbc     *Conn
 	ast     *sqlparser.Delete
+	vars    bindVars
 	table   string
 	filters string
 }
@@ -46,7 +46,7 @@ func (exr *deleteExecutor) Run() (result driver.Result, err error) {
 	}
 
 	if resp, err = exr.dbc.call(exr.ctx, req); err != nil {
-		log.Error(exr.ctx, "db.delete", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
+		exr.dbc.logger().Error(exr.ctx, "db.delete", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
 		return nil, driver.ErrBadConn
 	}
 
@@ -54,19 +54,19 @@ func (exr *deleteExecutor) Run() (result driver.Result, err error) {
 		sqlInfo := resp.GetSqlInfo()
 		if sqlInfo == nil {
 			sqlInfo = &MysqlInfo{
-				Sql:    astValue(exr.ast),
+				Sql:    exr.vars.value(exr.ast),
 				Vsid:   utils.GetInt32(bigid.GetVSId(exr.BigId)),
 				Dbname: exr.DBName,
 			}
 		}
 		err = NewDBError(resp.GetError(), resp.GetErrMsg(), sqlInfo)
-		log.Error(exr.ctx, "db.delete", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
+		exr.dbc.logger().Error(exr.ctx, "db.delete", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
 		return
 	}
 
 	deleteResp := resp.GetDeleteResp()
 	if deleteResp == nil {
-		log.Error(exr.ctx, "db.delete", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_Id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", "no delete response")
+		exr.dbc.logger().Error(exr.ctx, "db.delete", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_Id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", "no delete response")
 		return nil, driver.ErrBadConn
 	}
 
@@ -78,27 +78,27 @@ func (exr *deleteExecutor) Run() (result driver.Result, err error) {
 
 func (exr *deleteExecutor) parse() error {
 	if exr.ast.Where == nil {
-		panic(ErrSqlInvalid("must have `where` conditions"))
+		return newSQLValidationError("where", "must have `where` conditions")
 	}
 
 	if exr.ast.Where.Type != "where" {
-		panic(ErrSqlInvalid(fmt.Sprintf("filters `%v` not supported", exr.ast.Where.Type)))
+		return newSQLValidationError("where", fmt.Sprintf("filters `%v` not supported", exr.ast.Where.Type))
 	}
 
 	if exr.ast.Limit != nil {
-		panic(ErrSqlInvalid("`limit` not supported"))
+		return newSQLValidationError("limit", "`limit` not supported")
 	}
 
-	if len(astValue(exr.ast.OrderBy)) > 0 {
-		panic(ErrSqlInvalid("`order by` not supported"))
+	if len(exr.vars.value(exr.ast.OrderBy)) > 0 {
+		return newSQLValidationError("order by", "`order by` not supported")
 	}
 
 	if exr.DBName == "" {
 		exr.DBName = exr.dbc.DBName
 	}
 
-	exr.table = astValue(exr.ast.Table)
-	exr.filters = astValue(exr.ast.Where.Expr)
+	exr.table = exr.vars.value(exr.ast.Table)
+	exr.filters = exr.vars.value(exr.ast.Where.Expr)
 	return nil
 
 }
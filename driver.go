@@ -6,7 +6,6 @@ import (
 	"database/sql/driver"
 
 	"github.com/stn81/log"
-	"github.com/stn81/knet"
 )
 
 var (
@@ -16,51 +15,22 @@ var (
 
 type CdbPoolDriver struct{}
 
-func (d CdbPoolDriver) Open(dsn string) (conn driver.Conn, err error) {
-	dbc := newConn()
-
-	if Debug {
-		log.Debug(mctx, "driver.Open()", "conn_id", dbc.id)
-	}
-
-	if dbc.Config, err = ParseDSN(dsn); err != nil {
-		return
-	}
-
-	conf := knet.NewTCPClientConfig()
-	if dbc.Timeout > 0 {
-		conf.DialTimeout = dbc.Timeout
-	}
-	if dbc.ReadTimeout > 0 {
-		conf.Io.ReadTimeout = dbc.ReadTimeout
-	}
-	if dbc.WriteTimeout > 0 {
-		conf.Io.WriteTimeout = dbc.WriteTimeout
-	}
-
-	dbc.client = knet.NewTCPClient(mctx, conf)
-	if dbc.EnableCircuitBreaker {
-		dbc.client = knet.NewCircuitBreakerClient(dbc.client, getBreaker(dbc.Addr))
-	}
-
-	dbc.client.SetProtocol(&Protocol{})
-	dbc.client.SetIoHandler(dbc)
-
-	if Debug {
-		log.Debug(mctx, "dail to server begin", "conn_id", dbc.id, "remote_addr", dbc.Addr)
-	}
-
-	if err = dbc.client.Dial(dbc.Addr); err != nil {
-		dbc.client.Close()
-		return
+func (d CdbPoolDriver) Open(dsn string) (driver.Conn, error) {
+	c, err := d.OpenConnector(dsn)
+	if err != nil {
+		return nil, err
 	}
+	return c.Connect(context.Background())
+}
 
-	if Debug {
-		log.Debug(mctx, "dail to server end", "conn_id", dbc.id, "remote_addr", dbc.Addr)
+// OpenConnector implements driver.DriverContext, so that the DSN is parsed
+// once per sql.DB instead of once per connection.
+func (d CdbPoolDriver) OpenConnector(dsn string) (driver.Connector, error) {
+	cfg, err := ParseDSN(dsn)
+	if err != nil {
+		return nil, err
 	}
-
-	conn = dbc
-	return
+	return NewConnector(cfg)
 }
 
 func init() {
@@ -3,9 +3,22 @@ package cdbpool
 import (
 	"bytes"
 	"errors"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/sony/gobreaker"
+	"github.com/stn81/knet"
+)
+
+const (
+	defaultNet               = "tcp"
+	defaultAddr              = "127.0.0.1:9123"
+	defaultMultiplexSessions = 2
+
+	defaultMultiplexTxSessions     = 16
+	defaultMultiplexIdleTxSessions = 2
 )
 
 var (
@@ -13,17 +26,85 @@ var (
 	errInvalidDSNAddr            = errors.New("invalid DSN: network address not terminated (missing closing brace)")
 	errInvalidDSNNoSlash         = errors.New("invalid DSN: missing the slash separating the database name")
 	errInvalidDSNUnsafeCollation = errors.New("invalid DSN: interpolateParams can not be used with unsafe collations")
+	errInvalidDSNBalancer        = errors.New("invalid DSN: unknown balancer")
 )
 
 type Config struct {
-	Net                  string        // Protocol, "tcp"
-	Addr                 string        // Network address (requires Net)
-	DBName               string        // Database name
-	MaxAllowedPacket     int           // Max packet size allowed
-	Timeout              time.Duration // Dial timeout
-	ReadTimeout          time.Duration // I/O read timeout
-	WriteTimeout         time.Duration // I/O write timeout
-	EnableCircuitBreaker bool
+	Net                     string        // Protocol, "tcp"
+	Addr                    string        // Network address (requires Net)
+	DBName                  string        // Database name
+	MaxAllowedPacket        int           // Max packet size allowed
+	Timeout                 time.Duration // Dial timeout
+	ReadTimeout             time.Duration // I/O read timeout
+	WriteTimeout            time.Duration // I/O write timeout
+	EnableCircuitBreaker    bool
+	EmptyAsNull             bool           // Return untyped empty values as NULL instead of ""
+	PanicOnInvalidSQL       bool           // Panic instead of returning SQL validation errors
+	Balancer                string         // Balancer of a Cluster, see the Balancer* constants
+	Loc                     *time.Location // Location of the times sent and received, UTC if nil
+	RawTime                 bool           // Return TIME values as strings, DSN parseTime=false
+	Multiplex               bool           // Share a few sessions per server between the connections
+	MultiplexSessions       int            // Max shared sessions per server with Multiplex, 2 if zero
+	MultiplexTxSessions     int            // Max transactions in progress per server with Multiplex, 16 if zero
+	MultiplexIdleTxSessions int            // Max transaction sessions kept idle per server with Multiplex, 2 if zero, none if negative
+
+	// The fields below can only be set programmatically, see NewConnector.
+	NewClient       func(cfg *Config) knet.Client // Custom dialer, returning the unconnected transport
+	Logger          Logger                        // Logger of the connections, github.com/stn81/log if nil
+	BreakerSettings *gobreaker.Settings           // Circuit breaker settings, used with EnableCircuitBreaker
+	Hooks           *Hooks                        // Hooks called around every request
+	RouteResolver   RouteResolver                 // Derives the bigId from the statement, see ShardKeyResolver
+}
+
+// NewConfig returns a Config with the default network and address.
+func NewConfig() *Config {
+	return &Config{
+		Net:  defaultNet,
+		Addr: defaultAddr,
+	}
+}
+
+// location returns the location of the times sent and received.
+func (cfg *Config) location() *time.Location {
+	if cfg == nil || cfg.Loc == nil {
+		return time.UTC
+	}
+	return cfg.Loc
+}
+
+// multiplexSessions returns the max number of shared sessions per server.
+func (cfg *Config) multiplexSessions() int {
+	if cfg.MultiplexSessions <= 0 {
+		return defaultMultiplexSessions
+	}
+	return cfg.MultiplexSessions
+}
+
+// multiplexTxSessions returns the max number of transactions in progress
+// per server, each on its own session.
+func (cfg *Config) multiplexTxSessions() int {
+	if cfg.MultiplexTxSessions <= 0 {
+		return defaultMultiplexTxSessions
+	}
+	return cfg.MultiplexTxSessions
+}
+
+// multiplexIdleTxSessions returns the max number of transaction sessions
+// kept for the next transactions.
+func (cfg *Config) multiplexIdleTxSessions() int {
+	switch {
+	case cfg.MultiplexIdleTxSessions < 0:
+		return 0
+	case cfg.MultiplexIdleTxSessions == 0:
+		return defaultMultiplexIdleTxSessions
+	}
+	return cfg.MultiplexIdleTxSessions
+}
+
+// Clone returns a shallow copy of cfg.
+func (cfg *Config) Clone() *Config {
+	cp := *cfg
+	return &cp
 }
 
 func (cfg *Config) FormatDSN() string {
@@ -83,6 +164,92 @@ func (cfg *Config) FormatDSN() string {
 		buf.WriteString(strconv.Itoa(cfg.MaxAllowedPacket))
 	}
 
+	if cfg.EmptyAsNull {
+		if hasParam {
+			buf.WriteString("&emptyAsNull=true")
+		} else {
+			hasParam = true
+			buf.WriteString("?emptyAsNull=true")
+		}
+	}
+
+	if cfg.PanicOnInvalidSQL {
+		if hasParam {
+			buf.WriteString("&panicOnInvalidSQL=true")
+		} else {
+			hasParam = true
+			buf.WriteString("?panicOnInvalidSQL=true")
+		}
+	}
+
+	if cfg.Loc != nil && cfg.Loc != time.UTC {
+		if hasParam {
+			buf.WriteString("&loc=")
+		} else {
+			hasParam = true
+			buf.WriteString("?loc=")
+		}
+		buf.WriteString(url.QueryEscape(cfg.Loc.String()))
+	}
+
+	if cfg.RawTime {
+		if hasParam {
+			buf.WriteString("&parseTime=false")
+		} else {
+			hasParam = true
+			buf.WriteString("?parseTime=false")
+		}
+	}
+
+	if cfg.Multiplex {
+		if hasParam {
+			buf.WriteString("&multiplex=true")
+		} else {
+			hasParam = true
+			buf.WriteString("?multiplex=true")
+		}
+	}
+
+	if cfg.MultiplexSessions > 0 {
+		if hasParam {
+			buf.WriteString("&multiplexSessions=")
+		} else {
+			hasParam = true
+			buf.WriteString("?multiplexSessions=")
+		}
+		buf.WriteString(strconv.Itoa(cfg.MultiplexSessions))
+	}
+
+	if cfg.MultiplexTxSessions > 0 {
+		if hasParam {
+			buf.WriteString("&multiplexTxSessions=")
+		} else {
+			hasParam = true
+			buf.WriteString("?multiplexTxSessions=")
+		}
+		buf.WriteString(strconv.Itoa(cfg.MultiplexTxSessions))
+	}
+
+	if cfg.MultiplexIdleTxSessions != 0 {
+		if hasParam {
+			buf.WriteString("&multiplexIdleTxSessions=")
+		} else {
+			hasParam = true
+			buf.WriteString("?multiplexIdleTxSessions=")
+		}
+		buf.WriteString(strconv.Itoa(cfg.MultiplexIdleTxSessions))
+	}
+
+	if len(cfg.Balancer) > 0 {
+		if hasParam {
+			buf.WriteString("&balancer=")
+		} else {
+			hasParam = true
+			buf.WriteString("?balancer=")
+		}
+		buf.WriteString(cfg.Balancer)
+	}
+
 	return buf.String()
 }
 
@@ -139,7 +306,7 @@ func ParseDSN(dsn string) (cfg *Config, err error) {
 
 	// Set default address if empty
 	if cfg.Addr == "" {
-		cfg.Addr = "127.0.0.1:9123"
+		cfg.Addr = defaultAddr
 	}
 
 	return
@@ -186,6 +353,66 @@ func parseDSNParams(cfg *Config, params string) (err error) {
 			if err != nil {
 				return
 			}
+
+		// Return untyped empty values as NULL instead of ""
+		case "emptyAsNull":
+			cfg.EmptyAsNull, err = strconv.ParseBool(value)
+			if err != nil {
+				return
+			}
+
+		// Panic instead of returning SQL validation errors
+		case "panicOnInvalidSQL":
+			cfg.PanicOnInvalidSQL, err = strconv.ParseBool(value)
+			if err != nil {
+				return
+			}
+
+		// Time location
+		case "loc":
+			if value, err = url.QueryUnescape(value); err != nil {
+				return
+			}
+			if cfg.Loc, err = time.LoadLocation(value); err != nil {
+				return
+			}
+
+		// Return TIME values as time.Time
+		case "parseTime":
+			var parseTime bool
+			if parseTime, err = strconv.ParseBool(value); err != nil {
+				return
+			}
+			cfg.RawTime = !parseTime
+
+		// Share sessions between the connections
+		case "multiplex":
+			cfg.Multiplex, err = strconv.ParseBool(value)
+			if err != nil {
+				return
+			}
+		case "multiplexSessions":
+			cfg.MultiplexSessions, err = strconv.Atoi(value)
+			if err != nil {
+				return
+			}
+		case "multiplexTxSessions":
+			cfg.MultiplexTxSessions, err = strconv.Atoi(value)
+			if err != nil {
+				return
+			}
+		case "multiplexIdleTxSessions":
+			cfg.MultiplexIdleTxSessions, err = strconv.Atoi(value)
+			if err != nil {
+				return
+			}
+
+		// Balancer of a Cluster
+		case "balancer":
+			if _, err = newBalancer(value); err != nil {
+				return
+			}
+			cfg.Balancer = value
 		default:
 		}
 	}
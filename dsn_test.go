@@ -17,3 +17,95 @@ func TestParseDSN(t *testing.T) {
 	t.Logf("dsn.ReadTimeout: %v", cfg.ReadTimeout)
 	t.Logf("dsn.WriteTimeout: %v", cfg.WriteTimeout)
 }
+
+func TestParseDSNEmptyAsNull(t *testing.T) {
+	cfg, err := ParseDSN("tcp(127.0.0.1:9123)/users?emptyAsNull=true")
+	if err != nil {
+		t.Fatalf("parse error:%v", err)
+	}
+
+	if !cfg.EmptyAsNull {
+		t.Errorf("dsn.EmptyAsNull: got false, want true")
+	}
+
+	dsn := cfg.FormatDSN()
+	if cfg, err = ParseDSN(dsn); err != nil {
+		t.Fatalf("parse error:%v", err)
+	}
+
+	if !cfg.EmptyAsNull {
+		t.Errorf("FormatDSN() lost emptyAsNull: %v", dsn)
+	}
+
+	for dsn, want := range map[string]bool{
+		"tcp(127.0.0.1:9123)/users":                   false,
+		"tcp(127.0.0.1:9123)/users?emptyAsNull=false": false,
+	} {
+		if cfg, err = ParseDSN(dsn); err != nil || cfg.EmptyAsNull != want {
+			t.Errorf("ParseDSN(%q): got emptyAsNull=%v, err=%v, want %v", dsn, cfg.EmptyAsNull, err, want)
+		}
+	}
+}
+
+func TestParseDSNBalancer(t *testing.T) {
+	cfg, err := ParseDSN("tcp(127.0.0.1:9123#2,127.0.0.1:9124)/users?balancer=p2c")
+	if err != nil {
+		t.Fatalf("parse error:%v", err)
+	}
+
+	if cfg.Balancer != BalancerP2C || cfg.Addr != "127.0.0.1:9123#2,127.0.0.1:9124" {
+		t.Errorf("got balancer=%v, addr=%v", cfg.Balancer, cfg.Addr)
+	}
+
+	if _, err = ParseDSN("tcp(127.0.0.1:9123)/users?balancer=fastest"); err != errInvalidDSNBalancer {
+		t.Errorf("unknown balancer: got err %v, want %v", err, errInvalidDSNBalancer)
+	}
+}
+
+func TestParseDSNTime(t *testing.T) {
+	cfg, err := ParseDSN("tcp(127.0.0.1:9123)/users?loc=Asia%2FShanghai&parseTime=false")
+	if err != nil {
+		t.Fatalf("parse error:%v", err)
+	}
+
+	if cfg.Loc == nil || cfg.Loc.String() != "Asia/Shanghai" || !cfg.RawTime {
+		t.Errorf("got loc=%v, rawTime=%v", cfg.Loc, cfg.RawTime)
+	}
+
+	dsn := cfg.FormatDSN()
+	if cfg, err = ParseDSN(dsn); err != nil {
+		t.Fatalf("parse error:%v", err)
+	}
+	if cfg.Loc == nil || cfg.Loc.String() != "Asia/Shanghai" || !cfg.RawTime {
+		t.Errorf("FormatDSN() lost loc or parseTime: %v", dsn)
+	}
+}
+
+func TestParseDSNMultiplex(t *testing.T) {
+	cfg, err := ParseDSN("tcp(127.0.0.1:9123)/users?multiplex=true&multiplexSessions=4")
+	if err != nil {
+		t.Fatalf("parse error:%v", err)
+	}
+	if !cfg.Multiplex || cfg.multiplexSessions() != 4 {
+		t.Errorf("got multiplex=%v, sessions=%v", cfg.Multiplex, cfg.multiplexSessions())
+	}
+
+	if cfg, err = ParseDSN(cfg.FormatDSN()); err != nil || !cfg.Multiplex || cfg.MultiplexSessions != 4 {
+		t.Errorf("FormatDSN() lost multiplex: %+v, err=%v", cfg, err)
+	}
+
+	if cfg = NewConfig(); cfg.multiplexSessions() != defaultMultiplexSessions {
+		t.Errorf("default sessions: got %v, want %v", cfg.multiplexSessions(), defaultMultiplexSessions)
+	}
+	if cfg.multiplexTxSessions() != defaultMultiplexTxSessions || cfg.multiplexIdleTxSessions() != defaultMultiplexIdleTxSessions {
+		t.Errorf("default tx sessions: got %v, idle %v", cfg.multiplexTxSessions(), cfg.multiplexIdleTxSessions())
+	}
+
+	cfg, err = ParseDSN("tcp(127.0.0.1:9123)/users?multiplex=true&multiplexTxSessions=8&multiplexIdleTxSessions=-1")
+	if err != nil {
+		t.Fatalf("parse error:%v", err)
+	}
+	if cfg, err = ParseDSN(cfg.FormatDSN()); err != nil || cfg.multiplexTxSessions() != 8 || cfg.multiplexIdleTxSessions() != 0 {
+		t.Errorf("FormatDSN() lost tx sessions: %+v, err=%v", cfg, err)
+	}
+}
@@ -0,0 +1,114 @@
+package cdbpool
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/stn81/log"
+)
+
+const (
+	defaultHealthCheckInterval = 5 * time.Second
+	defaultHealthCheckTimeout  = time.Second
+	defaultFailThreshold       = 3
+	defaultSuccessThreshold    = 2
+)
+
+// HealthCheckConfig configures the background health checker of a Cluster.
+// Zero fields take their default value.
+type HealthCheckConfig struct {
+	Interval         time.Duration // Interval between probes, default 5s
+	Timeout          time.Duration // Timeout of a single probe, default 1s
+	FailThreshold    int           // Consecutive failures ejecting a node, default 3
+	SuccessThreshold int           // Consecutive successes re-admitting a node, default 2
+}
+
+func (conf *HealthCheckConfig) normalize() {
+	if conf.Interval <= 0 {
+		conf.Interval = defaultHealthCheckInterval
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = defaultHealthCheckTimeout
+	}
+	if conf.FailThreshold <= 0 {
+		conf.FailThreshold = defaultFailThreshold
+	}
+	if conf.SuccessThreshold <= 0 {
+		conf.SuccessThreshold = defaultSuccessThreshold
+	}
+}
+
+type healthChecker struct {
+	conf    HealthCheckConfig
+	cluster *Cluster
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newHealthChecker(cluster *Cluster, conf HealthCheckConfig) *healthChecker {
+	conf.normalize()
+	return &healthChecker{
+		conf:    conf,
+		cluster: cluster,
+		stop:    make(chan struct{}),
+	}
+}
+
+func (hc *healthChecker) start() {
+	hc.wg.Add(1)
+	go hc.loop()
+}
+
+func (hc *healthChecker) close() {
+	close(hc.stop)
+	hc.wg.Wait()
+}
+
+func (hc *healthChecker) loop() {
+	defer hc.wg.Done()
+
+	ticker := time.NewTicker(hc.conf.Interval)
+	defer ticker.Stop()
+
+	for {
+		hc.checkAll()
+
+		select {
+		case <-hc.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkAll pings every node concurrently with CmdPing.
+func (hc *healthChecker) checkAll() {
+	var wg sync.WaitGroup
+
+	for _, node := range hc.cluster.getNodes() {
+		wg.Add(1)
+		go func(node *Node) {
+			defer wg.Done()
+			hc.check(node)
+		}(node)
+	}
+	wg.Wait()
+}
+
+func (hc *healthChecker) check(node *Node) {
+	ctx, cancel := context.WithTimeout(mctx, hc.conf.Timeout)
+	err := node.DB.PingContext(ctx)
+	cancel()
+
+	state, changed := node.report(err, &hc.conf)
+	if !changed {
+		return
+	}
+
+	if state == NodeUnhealthy {
+		log.Error(mctx, "node ejected", "addr", node.Addr, "error", err)
+	} else {
+		log.Info(mctx, "node re-admitted", "addr", node.Addr)
+	}
+}
@@ -0,0 +1,233 @@
+package cdbpool
+
+import (
+	"context"
+	"database/sql/driver"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	keyHedging    = "__db_hedging__"
+	keyHedgedRead = "__db_hedged_read__"
+
+	// hedgingWindow is the number of recent read latencies the adaptive
+	// hedging delay is computed from.
+	hedgingWindow = 256
+	// hedgingMinSamples is the number of samples required before the
+	// adaptive delay is used instead of defaultHedgingDelay.
+	hedgingMinSamples     = 20
+	defaultHedgingDelay   = 50 * time.Millisecond
+	hedgingLatencyPercent = 0.95
+)
+
+type hedging struct {
+	delay    time.Duration
+	adaptive bool
+}
+
+// WithHedging enables hedged reads for the queries run with the returned
+// context by the Query and QueryRow methods of a DB of a Cluster: if a
+// SELECT has not returned within delay, it is sent to a second node, and
+// the first reply wins. Writes and statements inside transactions are never
+// hedged.
+func WithHedging(ctx context.Context, delay time.Duration) context.Context {
+	return context.WithValue(ctx, keyHedging, &hedging{delay: delay})
+}
+
+// WithAdaptiveHedging is like WithHedging, with the delay set to the 95th
+// percentile of the recent read latencies of the cluster.
+func WithAdaptiveHedging(ctx context.Context) context.Context {
+	return context.WithValue(ctx, keyHedging, &hedging{adaptive: true})
+}
+
+func getHedging(ctx context.Context) *hedging {
+	if h, ok := ctx.Value(keyHedging).(*hedging); ok {
+		return h
+	}
+	return nil
+}
+
+// latencyWindow keeps the most recent latencies of a cluster.
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples [hedgingWindow]time.Duration
+	n       int
+}
+
+func (w *latencyWindow) add(d time.Duration) {
+	w.mu.Lock()
+	w.samples[w.n%hedgingWindow] = d
+	w.n++
+	w.mu.Unlock()
+}
+
+// percentile returns the p-th percentile of the samples, or false if there
+// are not enough samples.
+func (w *latencyWindow) percentile(p float64) (time.Duration, bool) {
+	w.mu.Lock()
+	n := w.n
+	if n > hedgingWindow {
+		n = hedgingWindow
+	}
+	if n < hedgingMinSamples {
+		w.mu.Unlock()
+		return 0, false
+	}
+	samples := make([]time.Duration, n)
+	copy(samples, w.samples[:n])
+	w.mu.Unlock()
+
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i] < samples[j]
+	})
+	return samples[int(float64(n-1)*p)], true
+}
+
+// isReadQuery reports whether query is a SELECT which may be hedged, that
+// is not a locking read.
+func isReadQuery(query string) bool {
+	q := strings.ToLower(trimComments(query))
+	if !strings.HasPrefix(q, "select") {
+		return false
+	}
+	return !strings.Contains(q, " for update") && !strings.Contains(q, " lock in share mode")
+}
+
+// trimComments returns query without its leading spaces and comments, such
+// as a route hint.
+func trimComments(query string) string {
+	for i := 0; i < len(query); {
+		switch c := query[i]; {
+		case isSpace(c):
+			i++
+		case c == '#' || strings.HasPrefix(query[i:], "-- "):
+			if i = skipLine(query, i); i < 0 {
+				return ""
+			}
+		case strings.HasPrefix(query[i:], "/*"):
+			j := strings.Index(query[i+2:], "*/")
+			if j < 0 {
+				return ""
+			}
+			i += j + 4
+		default:
+			return query[i:]
+		}
+	}
+	return ""
+}
+
+// hedgedRead is set by DB on the context of a read to hedge. The driver
+// runs the query on its connection, and on one of others if it has not
+// returned within delay.
+type hedgedRead struct {
+	cluster *Cluster
+	others  []*Node
+	delay   time.Duration
+}
+
+func getHedgedRead(ctx context.Context) *hedgedRead {
+	if hr, ok := ctx.Value(keyHedgedRead).(*hedgedRead); ok {
+		return hr
+	}
+	return nil
+}
+
+// hedge returns ctx with a hedgedRead if query is a read to hedge on a
+// node of the cluster of db.
+func (db *DB) hedge(ctx context.Context, query string) context.Context {
+	h := getHedging(ctx)
+	if h == nil || db.cluster == nil || !isReadQuery(query) {
+		return ctx
+	}
+
+	var (
+		c     = db.cluster
+		delay = h.delay
+	)
+
+	if h.adaptive {
+		var ok bool
+		if delay, ok = c.readLatency.percentile(hedgingLatencyPercent); !ok {
+			delay = defaultHedgingDelay
+		}
+	}
+
+	var others []*Node
+	for _, node := range c.healthyNodes() {
+		if node != db.node {
+			others = append(others, node)
+		}
+	}
+	if len(others) == 0 {
+		return ctx
+	}
+
+	return context.WithValue(ctx, keyHedgedRead, &hedgedRead{
+		cluster: c,
+		others:  others,
+		delay:   delay,
+	})
+}
+
+type hedgeResult struct {
+	rows driver.Rows
+	err  error
+}
+
+// query runs stmt, and the same query on another node if stmt has not
+// returned within the delay. The first reply wins. As the rows are read
+// before a query returns, the other query is cancelled at once.
+func (hr *hedgedRead) query(ctx context.Context, stmt *Stmt, args []driver.NamedValue) (driver.Rows, error) {
+	var (
+		_, route = stmt.route(ctx)
+		results  = make(chan *hedgeResult, 2)
+		timer    = time.NewTimer(hr.delay)
+		pending  = 1
+		wg       sync.WaitGroup
+	)
+
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, keyHedgedRead, (*hedgedRead)(nil)))
+	defer func() {
+		timer.Stop()
+		cancel()
+		// stmt must be done with its connection before it is released
+		wg.Wait()
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		rows, err := stmt.queryContext(ctx, args)
+		results <- &hedgeResult{rows: rows, err: err}
+	}()
+
+	for {
+		select {
+		case <-timer.C:
+			pending++
+			node := hr.cluster.getBalancer().Pick(route, hr.others)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var rows driver.Rows
+				err := rawConn(ctx, node.DB, func(dbc *Conn) (err error) {
+					other := *stmt
+					other.dbc = dbc
+					rows, err = other.queryContext(ctx, args)
+					return
+				})
+				results <- &hedgeResult{rows: rows, err: err}
+			}()
+		case res := <-results:
+			pending--
+			if res.err != nil && pending > 0 {
+				continue
+			}
+			return res.rows, res.err
+		}
+	}
+}
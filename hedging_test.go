@@ -0,0 +1,44 @@
+package cdbpool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsReadQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"select * from t where id=1", true},
+		{"  SELECT id FROM t", true},
+		{"/*+ cdbpool: bigid=1 */ select * from t", true},
+		{"-- read\n# again\n/* x */select 1", true},
+		{"/* select */ update t set a=1", false},
+		{"/* select", false},
+		{"select * from t where id=1 for update", false},
+		{"select * from t where id=1 lock in share mode", false},
+		{"update t set a=1 where id=1", false},
+		{"show tables", false},
+	}
+
+	for _, test := range tests {
+		if got := isReadQuery(test.query); got != test.want {
+			t.Errorf("isReadQuery(%q): got %v, want %v", test.query, got, test.want)
+		}
+	}
+}
+
+func TestLatencyWindowPercentile(t *testing.T) {
+	var w latencyWindow
+	if _, ok := w.percentile(hedgingLatencyPercent); ok {
+		t.Errorf("percentile of an empty window: got ok")
+	}
+
+	for i := 1; i <= 2*hedgingWindow; i++ {
+		w.add(time.Duration(i%100) * time.Millisecond)
+	}
+	if d, ok := w.percentile(hedgingLatencyPercent); !ok || d < 90*time.Millisecond || d > 99*time.Millisecond {
+		t.Errorf("p95: got %v, %v", d, ok)
+	}
+}
@@ -3,7 +3,6 @@ package cdbpool
 import (
 	"bytes"
 	"database/sql/driver"
-	"fmt"
 
 	"github.com/stn81/sqlparser"
 )
@@ -19,6 +18,17 @@ func driverArgs(args []driver.Value) []driver.NamedValue {
 	return nargs
 }
 
+// QuoteString returns s as a quoted mysql string literal, escaped like the
+// string arguments interpolated into the queries.
+func QuoteString(s string) string {
+	var buf bytes.Buffer
+	buf.Grow(len(s) + 2)
+	buf.WriteByte('\'')
+	escapeStringVal(&buf, s)
+	buf.WriteByte('\'')
+	return buf.String()
+}
+
 func escapeStringVal(buf *bytes.Buffer, v string) {
 	for i := 0; i < len(v); i++ {
 		c := v[i]
@@ -59,5 +69,5 @@ func astValue(v sqlparser.SQLNode) string {
 }
 
 func ErrSqlInvalid(detail string) error {
-	return fmt.Errorf("sql invalid: %s", detail)
+	return newSQLValidationError("", detail)
 }
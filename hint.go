@@ -0,0 +1,86 @@
+package cdbpool
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	hintBegin  = "/*+"
+	hintEnd    = "*/"
+	hintPrefix = "cdbpool:"
+)
+
+// parseRouteHint extracts the route given by a comment such as
+//
+//	/*+ cdbpool: db=orders bigid=123 offline=1 */
+//
+// and returns the query without the comment. The route is nil if the query
+// has no cdbpool hint. bigid is required, db defaults to the DSN database.
+func parseRouteHint(query string) (*RouteInfo, string, error) {
+	var (
+		begin, end = -1, -1
+		content    string
+	)
+
+	// Hints in quoted strings and other comments are not hints.
+	for i := 0; i < len(query) && begin < 0; i++ {
+		if !strings.HasPrefix(query[i:], hintBegin) {
+			if i = skipQuotedOrComment(query, i); i < 0 {
+				break
+			}
+			continue
+		}
+
+		j := strings.Index(query[i:], hintEnd)
+		if j < 0 {
+			break
+		}
+		end = i + j + len(hintEnd)
+
+		content = strings.TrimSpace(query[i+len(hintBegin) : end-len(hintEnd)])
+		if strings.HasPrefix(content, hintPrefix) {
+			begin, content = i, content[len(hintPrefix):]
+		}
+		i = end - 1
+	}
+
+	if begin < 0 {
+		return nil, query, nil
+	}
+
+	var (
+		route    = &RouteInfo{}
+		hasBigId bool
+		err      error
+	)
+
+	for _, field := range strings.Fields(content) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return nil, query, newSQLValidationError("hint", "invalid cdbpool hint: "+field)
+		}
+
+		switch key, value := kv[0], kv[1]; key {
+		case "db":
+			route.DBName = value
+		case "bigid":
+			if route.BigId, err = strconv.ParseUint(value, 10, 64); err != nil {
+				return nil, query, newSQLValidationError("hint", "invalid cdbpool hint bigid: "+value)
+			}
+			hasBigId = true
+		case "offline":
+			if route.Offline, err = strconv.ParseBool(value); err != nil {
+				return nil, query, newSQLValidationError("hint", "invalid cdbpool hint offline: "+value)
+			}
+		default:
+			return nil, query, newSQLValidationError("hint", "unknown cdbpool hint: "+key)
+		}
+	}
+
+	if !hasBigId {
+		return nil, query, newSQLValidationError("hint", "cdbpool hint without bigid")
+	}
+
+	return route, strings.TrimSpace(query[:begin] + " " + query[end:]), nil
+}
@@ -0,0 +1,48 @@
+package cdbpool
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRouteHint(t *testing.T) {
+	tests := []struct {
+		query string
+		route *RouteInfo
+		rest  string
+		err   bool
+	}{
+		{"select * from t where id=1", nil, "select * from t where id=1", false},
+		{"/* comment */ select 1", nil, "/* comment */ select 1", false},
+		{"/*+ cdbpool: db=orders bigid=123 offline=1 */ select * from t", &RouteInfo{DBName: "orders", BigId: 123, Offline: true}, "select * from t", false},
+		{"select * from t /*+cdbpool:bigid=7*/", &RouteInfo{BigId: 7}, "select * from t", false},
+		{"/*+ index(t) */ select /*+ cdbpool: bigid=7 */ * from t", &RouteInfo{BigId: 7}, "/*+ index(t) */ select   * from t", false},
+		{"select * from t where note = '/*+ cdbpool: bigid=1 */'", nil, "select * from t where note = '/*+ cdbpool: bigid=1 */'", false},
+		{`select "it's /*+ cdbpool: bigid=1 */" /*+ cdbpool: bigid=7 */`, &RouteInfo{BigId: 7}, `select "it's /*+ cdbpool: bigid=1 */"`, false},
+		{"select `/*+ cdbpool: bigid=1 */` from t", nil, "select `/*+ cdbpool: bigid=1 */` from t", false},
+		{"select 1 -- /*+ cdbpool: bigid=1 */", nil, "select 1 -- /*+ cdbpool: bigid=1 */", false},
+		{"/*+ cdbpool: db=orders */ select 1", nil, "", true},
+		{"/*+ cdbpool: bigid=x */ select 1", nil, "", true},
+		{"/*+ cdbpool: bigid=1 shard=2 */ select 1", nil, "", true},
+	}
+
+	for _, test := range tests {
+		route, rest, err := parseRouteHint(test.query)
+		if test.err {
+			if !errors.Is(err, ErrInvalidSQL) {
+				t.Errorf("parseRouteHint(%q): got err %v, want ErrInvalidSQL", test.query, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRouteHint(%q): err=%v", test.query, err)
+			continue
+		}
+		if rest != test.rest {
+			t.Errorf("parseRouteHint(%q): got query %q, want %q", test.query, rest, test.rest)
+		}
+		if (route == nil) != (test.route == nil) || route != nil && *route != *test.route {
+			t.Errorf("parseRouteHint(%q): got route %+v, want %+v", test.query, route, test.route)
+		}
+	}
+}
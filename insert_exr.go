@@ -7,7 +7,6 @@ import (
 	"strings"
 
 	"github.com/stn81/bigid"
-	"github.com/stn81/log"
 	"github.com/stn81/sqlparser"
 	"github.com/stn81/kate/utils"
 )
@@ -17,6 +16,7 @@ type insertExecutor struct {
 	ctx     context.Context
 	dbc     *Conn
 	ast     *sqlparser.Insert
+	vars    bindVars
 	table   string
 	columns string
 	values  string
@@ -50,7 +50,7 @@ func (exr *insertExecutor) Run() (result driver.Result, err error) {
 	}
 
 	if resp, err = exr.dbc.call(exr.ctx, req); err != nil {
-		log.Error(exr.ctx, "db.insert", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
+		exr.dbc.logger().Error(exr.ctx, "db.insert", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
 		return nil, driver.ErrBadConn
 	}
 
@@ -58,19 +58,19 @@ func (exr *insertExecutor) Run() (result driver.Result, err error) {
 		sqlInfo := resp.GetSqlInfo()
 		if sqlInfo == nil {
 			sqlInfo = &MysqlInfo{
-				Sql:    astValue(exr.ast),
+				Sql:    exr.vars.value(exr.ast),
 				Vsid:   utils.GetInt32(bigid.GetVSId(exr.BigId)),
 				Dbname: exr.DBName,
 			}
 		}
 		err = NewDBError(resp.GetError(), resp.GetErrMsg(), sqlInfo)
-		log.Error(exr.ctx, "db.insert", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
+		exr.dbc.logger().Error(exr.ctx, "db.insert", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
 		return
 	}
 
 	insertResp := resp.GetInsertResp()
 	if insertResp == nil {
-		log.Error(exr.ctx, "db.insert", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", "no insert response")
+		exr.dbc.logger().Error(exr.ctx, "db.insert", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", "no insert response")
 		return nil, driver.ErrBadConn
 	}
 
@@ -92,8 +92,8 @@ func (exr *insertExecutor) parse() error {
 		exr.DBName = exr.dbc.DBName
 	}
 
-	exr.table = astValue(exr.ast.Table)
-	exr.columns = astValue(exr.ast.Columns)
-	exr.values = strings.TrimPrefix(astValue(exr.ast.Rows), "values ") + astValue(exr.ast.OnDup)
+	exr.table = exr.vars.value(exr.ast.Table)
+	exr.columns = exr.vars.value(exr.ast.Columns)
+	exr.values = strings.TrimPrefix(exr.vars.value(exr.ast.Rows), "values ") + exr.vars.value(exr.ast.OnDup)
 	return nil
 }
@@ -0,0 +1,135 @@
+package cdbpool
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+const (
+	interpolateTimeFormat = "2006-01-02 15:04:05.999999"
+	zeroTimeLiteral       = "'0000-00-00 00:00:00'"
+)
+
+// writeArg writes the SQL literal of the argument v. Times are written in
+// loc.
+func writeArg(buf *bytes.Buffer, v interface{}, loc *time.Location) error {
+	if valuer, ok := v.(driver.Valuer); ok {
+		if isNilPointer(v) {
+			buf.WriteString("NULL")
+			return nil
+		}
+		dv, err := valuer.Value()
+		if err != nil {
+			return err
+		}
+		if _, ok := dv.(driver.Valuer); ok {
+			return ErrUnsupportedArg
+		}
+		v = dv
+	}
+
+	switch x := v.(type) {
+	case nil:
+		buf.WriteString("NULL")
+	case int:
+		buf.WriteString(strconv.FormatInt(int64(x), 10))
+	case int8:
+		buf.WriteString(strconv.FormatInt(int64(x), 10))
+	case int16:
+		buf.WriteString(strconv.FormatInt(int64(x), 10))
+	case int32:
+		buf.WriteString(strconv.FormatInt(int64(x), 10))
+	case int64:
+		buf.WriteString(strconv.FormatInt(x, 10))
+	case uint:
+		buf.WriteString(strconv.FormatUint(uint64(x), 10))
+	case uint8:
+		buf.WriteString(strconv.FormatUint(uint64(x), 10))
+	case uint16:
+		buf.WriteString(strconv.FormatUint(uint64(x), 10))
+	case uint32:
+		buf.WriteString(strconv.FormatUint(uint64(x), 10))
+	case uint64:
+		buf.WriteString(strconv.FormatUint(x, 10))
+	case float32:
+		buf.WriteString(strconv.FormatFloat(float64(x), 'g', -1, 32))
+	case float64:
+		buf.WriteString(strconv.FormatFloat(x, 'g', -1, 64))
+	case bool:
+		if x {
+			buf.WriteByte('1')
+		} else {
+			buf.WriteByte('0')
+		}
+	case string:
+		buf.WriteByte('\'')
+		escapeStringVal(buf, x)
+		buf.WriteByte('\'')
+	case json.RawMessage:
+		if x == nil {
+			buf.WriteString("NULL")
+			break
+		}
+		buf.WriteByte('\'')
+		escapeStringVal(buf, string(x))
+		buf.WriteByte('\'')
+	case []byte:
+		if x == nil {
+			buf.WriteString("NULL")
+			break
+		}
+		buf.WriteString("X'")
+		buf.WriteString(hex.EncodeToString(x))
+		buf.WriteByte('\'')
+	case time.Time:
+		if x.IsZero() {
+			buf.WriteString(zeroTimeLiteral)
+			break
+		}
+		buf.WriteByte('\'')
+		buf.WriteString(x.In(loc).Format(interpolateTimeFormat))
+		buf.WriteByte('\'')
+	case *big.Int:
+		if x == nil {
+			buf.WriteString("NULL")
+			break
+		}
+		buf.WriteString(x.String())
+	case *big.Float:
+		if x == nil {
+			buf.WriteString("NULL")
+			break
+		}
+		if x.IsInf() {
+			return ErrUnsupportedArg
+		}
+		buf.WriteString(x.Text('f', -1))
+	default:
+		return ErrUnsupportedArg
+	}
+	return nil
+}
+
+// isNilPointer reports whether v is a nil pointer, which is written as
+// NULL instead of calling its Value method, like database/sql does.
+func isNilPointer(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
+// CheckNamedValue implements driver.NamedValueChecker. The types written
+// by the interpolation which database/sql would reject or convert, such
+// as json.RawMessage into []byte, are passed as is.
+func (c *Conn) CheckNamedValue(nv *driver.NamedValue) error {
+	switch nv.Value.(type) {
+	case json.RawMessage, *big.Int, *big.Float, uint64:
+		return nil
+	}
+	return driver.ErrSkip
+}
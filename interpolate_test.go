@@ -0,0 +1,97 @@
+package cdbpool
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+type testValuer struct {
+	v   driver.Value
+	err error
+}
+
+func (v testValuer) Value() (driver.Value, error) {
+	return v.v, v.err
+}
+
+func TestInterpolateParams(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	ts := time.Date(2017, 3, 1, 4, 30, 45, 123456000, time.UTC)
+	amount, _ := new(big.Float).SetPrec(128).SetString("12345678901234567890.25")
+
+	tests := []struct {
+		cfg  *Config
+		arg  interface{}
+		want string
+	}{
+		{&Config{}, nil, "NULL"},
+		{&Config{}, int64(-42), "-42"},
+		{&Config{}, uint64(1 << 63), "9223372036854775808"},
+		{&Config{}, 1.5, "1.5"},
+		{&Config{}, true, "1"},
+		{&Config{}, "it's", `'it\'s'`},
+		{&Config{}, []byte{0xde, 0xad}, "X'dead'"},
+		{&Config{}, []byte(nil), "NULL"},
+		{&Config{}, json.RawMessage(`{"a":"b"}`), `'{\"a\":\"b\"}'`},
+		{&Config{}, ts, "'2017-03-01 04:30:45.123456'"},
+		{&Config{Loc: shanghai}, ts, "'2017-03-01 12:30:45.123456'"},
+		{&Config{}, time.Time{}, "'0000-00-00 00:00:00'"},
+		{&Config{}, new(big.Int).Lsh(big.NewInt(1), 70), "1180591620717411303424"},
+		{&Config{}, amount, "12345678901234567890.25"},
+		{&Config{}, testValuer{v: "v"}, "'v'"},
+		{&Config{}, (*big.Int)(nil), "NULL"},
+	}
+
+	for _, test := range tests {
+		stmt, err := newStmt(context.Background(), &Conn{Config: test.cfg}, "select ?")
+		if err != nil {
+			t.Fatalf("newStmt: err=%v", err)
+		}
+
+		q, err := stmt.interpolateParams([]driver.NamedValue{{Ordinal: 1, Value: test.arg}})
+		if err != nil {
+			t.Errorf("interpolate %#v: err=%v", test.arg, err)
+			continue
+		}
+		if want := "select " + test.want; q != want {
+			t.Errorf("interpolate %#v: got %q, want %q", test.arg, q, want)
+		}
+	}
+}
+
+func TestInterpolateParamsError(t *testing.T) {
+	stmt, err := newStmt(context.Background(), &Conn{Config: &Config{}}, "select ? from t where id = ?")
+	if err != nil {
+		t.Fatalf("newStmt: err=%v", err)
+	}
+
+	valuerErr := errors.New("valuer failed")
+	tests := []struct {
+		arg interface{}
+		err error
+	}{
+		{struct{}{}, ErrUnsupportedArg},
+		{testValuer{err: valuerErr}, valuerErr},
+	}
+
+	for _, test := range tests {
+		_, err := stmt.interpolateParams([]driver.NamedValue{
+			{Ordinal: 1, Value: "a"},
+			{Ordinal: 2, Value: test.arg},
+		})
+
+		var argErr *ArgError
+		if !errors.As(err, &argErr) || argErr.Ordinal != 2 || !errors.Is(err, test.err) {
+			t.Errorf("interpolate %#v: got err %v, want ArgError of argument 2 wrapping %v", test.arg, err, test.err)
+		}
+	}
+
+	if _, err = stmt.interpolateParams([]driver.NamedValue{{Ordinal: 1, Value: "a"}}); !errors.Is(err, ErrInvalidSQL) {
+		t.Errorf("missing argument: got err %v, want ErrInvalidSQL", err)
+	}
+}
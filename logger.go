@@ -0,0 +1,36 @@
+package cdbpool
+
+import (
+	"context"
+
+	"github.com/stn81/log"
+)
+
+// Logger receives the log records of connections. The default logger
+// forwards them to github.com/stn81/log.
+type Logger interface {
+	Debug(ctx context.Context, msg string, keyvals ...interface{})
+	Info(ctx context.Context, msg string, keyvals ...interface{})
+	Error(ctx context.Context, msg string, keyvals ...interface{})
+}
+
+type defaultLogger struct{}
+
+func (defaultLogger) Debug(ctx context.Context, msg string, keyvals ...interface{}) {
+	log.Debug(ctx, msg, keyvals...)
+}
+
+func (defaultLogger) Info(ctx context.Context, msg string, keyvals ...interface{}) {
+	log.Info(ctx, msg, keyvals...)
+}
+
+func (defaultLogger) Error(ctx context.Context, msg string, keyvals ...interface{}) {
+	log.Error(ctx, msg, keyvals...)
+}
+
+func (cfg *Config) logger() Logger {
+	if cfg.Logger != nil {
+		return cfg.Logger
+	}
+	return defaultLogger{}
+}
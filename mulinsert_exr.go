@@ -0,0 +1,82 @@
+package cdbpool
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+
+	"github.com/stn81/bigid"
+	"github.com/stn81/kate/utils"
+)
+
+type mulInsertExecutor struct {
+	*RouteInfo
+	ctx        context.Context
+	dbc        *Conn
+	table      string
+	columns    []string
+	valueLists []*ValueList
+}
+
+func (exr *mulInsertExecutor) Run() (result driver.Result, err error) {
+	if exr.dbc.client == nil || !exr.dbc.client.IsConnected() {
+		return nil, driver.ErrBadConn
+	}
+
+	if exr.DBName == "" {
+		exr.DBName = exr.dbc.DBName
+	}
+
+	var (
+		req   *CdbPoolRequest
+		resp  *CdbPoolResponse
+		logId = fmt.Sprintf("%s.%s.mulinsert", exr.DBName, exr.table)
+	)
+
+	req = &CdbPoolRequest{
+		Logid:       logId,
+		Command:     "mulinsert",
+		Bigid:       exr.BigId,
+		NeedSqlInfo: true,
+		Req: &CdbPoolRequest_MulinsertReq{
+			&MulInsertRequest{
+				Dbname:     exr.DBName,
+				Table:      exr.table,
+				Columns:    exr.columns,
+				Valuelists: exr.valueLists,
+			},
+		},
+	}
+
+	if resp, err = exr.dbc.call(exr.ctx, req); err != nil {
+		exr.dbc.logger().Error(exr.ctx, "db.mulinsert", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
+		return nil, driver.ErrBadConn
+	}
+
+	if ResultCode(resp.GetError()) != ResultCode_RC_SUCCESS {
+		sqlInfo := resp.GetSqlInfo()
+		if sqlInfo == nil {
+			sqlInfo = &MysqlInfo{
+				Sql:    fmt.Sprintf("insert into %s(%s) values <%d rows>", exr.table, strings.Join(exr.columns, ", "), len(exr.valueLists)),
+				Vsid:   utils.GetInt32(bigid.GetVSId(exr.BigId)),
+				Dbname: exr.DBName,
+			}
+		}
+		err = NewDBError(resp.GetError(), resp.GetErrMsg(), sqlInfo)
+		exr.dbc.logger().Error(exr.ctx, "db.mulinsert", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
+		return
+	}
+
+	insertResp := resp.GetInsertResp()
+	if insertResp == nil {
+		exr.dbc.logger().Error(exr.ctx, "db.mulinsert", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", "no insert response")
+		return nil, driver.ErrBadConn
+	}
+
+	result = &Result{
+		lastInsertId: int64(insertResp.GetLastInsertid()),
+		rowsAffected: int64(insertResp.GetAffectRows()),
+	}
+	return
+}
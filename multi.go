@@ -0,0 +1,408 @@
+package cdbpool
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stn81/bigid"
+)
+
+const defaultMultiConcurrency = 8
+
+var (
+	errMultiColumns = errors.New("cdbpool: shards returned different columns")
+	errMultiNoConn  = errors.New("cdbpool: merged rows only support Query")
+)
+
+// ShardErrors maps vsids to the error of the query on that shard.
+type ShardErrors map[uint64]error
+
+func (e ShardErrors) Error() string {
+	vsids := make([]uint64, 0, len(e))
+	for vsid := range e {
+		vsids = append(vsids, vsid)
+	}
+	sort.Slice(vsids, func(i, j int) bool {
+		return vsids[i] < vsids[j]
+	})
+
+	msgs := make([]string, len(vsids))
+	for i, vsid := range vsids {
+		msgs[i] = fmt.Sprintf("vsid %v: %v", vsid, e[vsid])
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// MultiOption configures QueryMulti.
+type MultiOption func(opts *multiOptions)
+
+type multiOrder struct {
+	column string
+	desc   bool
+}
+
+type multiOptions struct {
+	concurrency int
+	offline     bool
+	partial     bool
+	orderBy     []multiOrder
+	limit       int
+	shardArgs   func(vsid uint64, bigIds []uint64) []interface{}
+}
+
+// MultiConcurrency sets the number of shards queried at once, 8 by default.
+func MultiConcurrency(n int) MultiOption {
+	return func(opts *multiOptions) {
+		opts.concurrency = n
+	}
+}
+
+// MultiOffline routes the shard queries to the offline databases.
+func MultiOffline() MultiOption {
+	return func(opts *multiOptions) {
+		opts.offline = true
+	}
+}
+
+// MultiPartial returns the rows of the successful shards along with the
+// ShardErrors, instead of no rows.
+func MultiPartial() MultiOption {
+	return func(opts *multiOptions) {
+		opts.partial = true
+	}
+}
+
+// MultiOrderBy sorts the merged rows by column. Calls are cumulative, the
+// first column being the primary sort key.
+func MultiOrderBy(column string, desc bool) MultiOption {
+	return func(opts *multiOptions) {
+		opts.orderBy = append(opts.orderBy, multiOrder{column: column, desc: desc})
+	}
+}
+
+// MultiLimit keeps the first n merged rows, after MultiOrderBy.
+func MultiLimit(n int) MultiOption {
+	return func(opts *multiOptions) {
+		opts.limit = n
+	}
+}
+
+// MultiShardArgs replaces the query args of each shard by the result of
+// fn, given the vsid and the bigIds of the shard, e.g. to only select
+// the bigIds of the shard with "where id in (?)".
+func MultiShardArgs(fn func(vsid uint64, bigIds []uint64) []interface{}) MultiOption {
+	return func(opts *multiOptions) {
+		opts.shardArgs = fn
+	}
+}
+
+type multiShard struct {
+	vsid    uint64
+	bigIds  []uint64
+	columns []string
+	rows    [][]driver.Value
+	err     error
+}
+
+// QueryMulti runs query on every shard of bigIds: the bigIds are grouped
+// by vsid and the query is sent once per vsid, routed to its first bigId,
+// with at most MultiConcurrency shards at once. The rows of all shards are
+// merged, then sorted and limited client side with MultiOrderBy and
+// MultiLimit.
+//
+// The failed shards are returned as ShardErrors, with no rows unless
+// MultiPartial is given.
+func QueryMulti(ctx context.Context, cluster *Cluster, dbName string, bigIds []uint64, query string, args []interface{}, opts ...MultiOption) (*sql.Rows, error) {
+	o := &multiOptions{concurrency: defaultMultiConcurrency}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.concurrency <= 0 {
+		o.concurrency = 1
+	}
+
+	var (
+		shards []*multiShard
+		byVSId = make(map[uint64]*multiShard)
+	)
+	for _, bigId := range bigIds {
+		vsid := bigid.GetVSId(bigId)
+		shard, ok := byVSId[vsid]
+		if !ok {
+			shard = &multiShard{vsid: vsid}
+			byVSId[vsid] = shard
+			shards = append(shards, shard)
+		}
+		shard.bigIds = append(shard.bigIds, bigId)
+	}
+
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, o.concurrency)
+	)
+	for _, shard := range shards {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(shard *multiShard) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			shardArgs := args
+			if o.shardArgs != nil {
+				shardArgs = o.shardArgs(shard.vsid, shard.bigIds)
+			}
+			db := cluster.GetDB(ctx, dbName, shard.bigIds[0], o.offline)
+			shard.columns, shard.rows, shard.err = queryValues(db, query, shardArgs)
+		}(shard)
+	}
+	wg.Wait()
+
+	var (
+		errs   = make(ShardErrors)
+		merged = &memRows{}
+	)
+	for _, shard := range shards {
+		if shard.err != nil {
+			errs[shard.vsid] = shard.err
+			continue
+		}
+		if len(shard.columns) == 0 {
+			continue
+		}
+		if merged.columns == nil {
+			merged.columns = shard.columns
+		} else if !equalColumns(merged.columns, shard.columns) {
+			return nil, errMultiColumns
+		}
+		merged.rows = append(merged.rows, shard.rows...)
+	}
+
+	if len(errs) > 0 && !o.partial {
+		return nil, errs
+	}
+
+	if err := merged.sort(o.orderBy); err != nil {
+		return nil, err
+	}
+	if o.limit > 0 && len(merged.rows) > o.limit {
+		merged.rows = merged.rows[:o.limit]
+	}
+
+	rows, err := merged.sqlRows(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		return rows, errs
+	}
+	return rows, nil
+}
+
+// queryValues runs query on db and reads all its rows as driver values.
+func queryValues(db *DB, query string, args []interface{}) ([]string, [][]driver.Value, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var values [][]driver.Value
+	for rows.Next() {
+		row := make([]driver.Value, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range dest {
+			dest[i] = &row[i]
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return nil, nil, err
+		}
+		values = append(values, row)
+	}
+	return columns, values, rows.Err()
+}
+
+func equalColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// memRows are in memory rows, exposed as *sql.Rows by sqlRows.
+type memRows struct {
+	columns []string
+	rows    [][]driver.Value
+	next    int
+}
+
+func (r *memRows) Columns() []string {
+	if r.columns == nil {
+		return []string{}
+	}
+	return r.columns
+}
+
+func (r *memRows) Close() error {
+	return nil
+}
+
+func (r *memRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
+
+// sort sorts the rows by the columns of orderBy.
+func (r *memRows) sort(orderBy []multiOrder) error {
+	if len(orderBy) == 0 {
+		return nil
+	}
+
+	indexes := make([]int, len(orderBy))
+	for i, order := range orderBy {
+		indexes[i] = -1
+		for j, column := range r.columns {
+			if strings.EqualFold(column, order.column) {
+				indexes[i] = j
+				break
+			}
+		}
+		if indexes[i] < 0 && len(r.rows) > 0 {
+			return fmt.Errorf("cdbpool: unknown order by column %q", order.column)
+		}
+	}
+
+	sort.SliceStable(r.rows, func(i, j int) bool {
+		for k, order := range orderBy {
+			c := compareValues(r.rows[i][indexes[k]], r.rows[j][indexes[k]])
+			if c == 0 {
+				continue
+			}
+			if order.desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+	return nil
+}
+
+// compareValues orders driver values like MySQL would, NULL first.
+func compareValues(a, b driver.Value) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+
+	switch x := a.(type) {
+	case int64:
+		switch y := b.(type) {
+		case int64:
+			return compareFloat(float64(x), float64(y))
+		case float64:
+			return compareFloat(float64(x), y)
+		}
+	case float64:
+		switch y := b.(type) {
+		case int64:
+			return compareFloat(x, float64(y))
+		case float64:
+			return compareFloat(x, y)
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			switch {
+			case x.Before(y):
+				return -1
+			case x.After(y):
+				return 1
+			}
+			return 0
+		}
+	case []byte:
+		if y, ok := b.([]byte); ok {
+			return bytes.Compare(x, y)
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func compareFloat(x, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// sqlRows returns the rows as *sql.Rows, from a single use in memory
+// database which is closed at once: its connection stays open until the
+// rows are closed.
+func (r *memRows) sqlRows(ctx context.Context) (*sql.Rows, error) {
+	db := sql.OpenDB(&memConnector{rows: r})
+	defer db.Close()
+	return db.QueryContext(ctx, "")
+}
+
+type memConnector struct {
+	rows *memRows
+}
+
+func (c *memConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &memConn{rows: c.rows}, nil
+}
+
+func (c *memConnector) Driver() driver.Driver {
+	return &CdbPoolDriver{}
+}
+
+type memConn struct {
+	rows *memRows
+}
+
+func (c *memConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errMultiNoConn
+}
+
+func (c *memConn) Close() error {
+	return nil
+}
+
+func (c *memConn) Begin() (driver.Tx, error) {
+	return nil, errMultiNoConn
+}
+
+func (c *memConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.rows, nil
+}
@@ -0,0 +1,252 @@
+package cdbpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/stn81/knet"
+)
+
+var errMuxClosed = errors.New("cdbpool: connector closed")
+
+// muxPool shares a few sessions to a server between the connections of a
+// connector, see Config.Multiplex. The requests of the connections are
+// pipelined on the sessions and their responses matched by ContextId.
+// Transactions are bound to the session by the server, so that they run on
+// dedicated sessions. At most MultiplexTxSessions of them are in use, and
+// MultiplexIdleTxSessions are kept for the next transactions.
+type muxPool struct {
+	cfg      *Config
+	mu       sync.Mutex
+	shared   []*muxSession
+	dialing  int
+	dialDone chan struct{} // closed when a dial ends, created by the waiters
+	idle     []knet.Client // dedicated sessions out of transaction
+	txSlots  chan struct{} // one token per dedicated session in use
+	closed   bool
+}
+
+type muxSession struct {
+	client knet.Client
+	refs   int
+}
+
+func newMuxPool(cfg *Config) *muxPool {
+	return &muxPool{
+		cfg:     cfg,
+		txSlots: make(chan struct{}, cfg.multiplexTxSessions()),
+	}
+}
+
+// dial connects a new session, giving up when ctx is done. The session of
+// a dial ending after that is closed.
+func (p *muxPool) dial(ctx context.Context) (knet.Client, error) {
+	var client knet.Client
+	if p.cfg.NewClient != nil {
+		client = p.cfg.NewClient(p.cfg)
+	} else {
+		client = newTCPClient(p.cfg)
+	}
+
+	client.SetProtocol(&Protocol{})
+	client.SetIoHandler(&muxHandler{cfg: p.cfg})
+
+	dialed := make(chan error, 1)
+	go func() {
+		dialed <- client.Dial(p.cfg.Addr)
+	}()
+
+	select {
+	case err := <-dialed:
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+		return client, nil
+	case <-ctx.Done():
+		go func() {
+			<-dialed
+			client.Close()
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// acquire returns the shared session used by the fewest connections,
+// dialing a new one while there are less than MultiplexSessions. It waits
+// for the sessions being dialed until ctx is done.
+func (p *muxPool) acquire(ctx context.Context) (knet.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for {
+		if p.closed {
+			return nil, errMuxClosed
+		}
+
+		// The connections of a dead session are discarded by database/sql
+		// on driver.ErrBadConn and release it later.
+		live := p.shared[:0]
+		for _, s := range p.shared {
+			if s.client.IsConnected() {
+				live = append(live, s)
+			}
+		}
+		p.shared = live
+
+		if len(p.shared)+p.dialing < p.cfg.multiplexSessions() {
+			p.dialing++
+			p.mu.Unlock()
+			client, err := p.dial(ctx)
+			p.mu.Lock()
+			p.dialing--
+			if p.dialDone != nil {
+				close(p.dialDone)
+				p.dialDone = nil
+			}
+
+			if err != nil {
+				return nil, err
+			}
+			if p.closed {
+				client.Close()
+				return nil, errMuxClosed
+			}
+			p.shared = append(p.shared, &muxSession{client: client, refs: 1})
+			return client, nil
+		}
+
+		if len(p.shared) > 0 {
+			least := p.shared[0]
+			for _, s := range p.shared[1:] {
+				if s.refs < least.refs {
+					least = s
+				}
+			}
+			least.refs++
+			return least.client, nil
+		}
+
+		// all the sessions are being dialed
+		if p.dialDone == nil {
+			p.dialDone = make(chan struct{})
+		}
+		done := p.dialDone
+		p.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			p.mu.Lock()
+			return nil, ctx.Err()
+		}
+		p.mu.Lock()
+	}
+}
+
+// release drops a reference to a shared session, closing it when unused.
+func (p *muxPool) release(client knet.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, s := range p.shared {
+		if s.client != client {
+			continue
+		}
+		if s.refs--; s.refs == 0 {
+			p.shared = append(p.shared[:i], p.shared[i+1:]...)
+			client.Close()
+		}
+		return
+	}
+
+	// dead session, already removed by acquire
+	client.Close()
+}
+
+// dedicated returns a session for a transaction, waiting until ctx is done
+// while MultiplexTxSessions are in use.
+func (p *muxPool) dedicated(ctx context.Context) (knet.Client, error) {
+	select {
+	case p.txSlots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	p.mu.Lock()
+	for len(p.idle) > 0 {
+		client := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if client.IsConnected() {
+			p.mu.Unlock()
+			return client, nil
+		}
+		client.Close()
+	}
+	p.mu.Unlock()
+
+	client, err := p.dial(ctx)
+	if err != nil {
+		<-p.txSlots
+		return nil, err
+	}
+	return client, nil
+}
+
+// releaseDedicated keeps the session of a finished transaction for the
+// next ones, unless reuse is false because the transaction may still be
+// running on it.
+func (p *muxPool) releaseDedicated(client knet.Client, reuse bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	defer func() { <-p.txSlots }()
+
+	if reuse && !p.closed && client.IsConnected() && len(p.idle) < p.cfg.multiplexIdleTxSessions() {
+		p.idle = append(p.idle, client)
+		return
+	}
+	client.Close()
+}
+
+// close closes the idle dedicated sessions, and fails the dials ending
+// later. The shared sessions are closed when the connections release them.
+func (p *muxPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for _, client := range p.idle {
+		client.Close()
+	}
+	p.idle = nil
+}
+
+// muxHandler handles the events of a shared or dedicated session.
+type muxHandler struct {
+	knet.IoHandlerAdapter
+	cfg *Config
+}
+
+func (h *muxHandler) OnIdle(session *knet.IoSession) error {
+	return pingIdle(session)
+}
+
+func (h *muxHandler) OnError(session *knet.IoSession, err error) {
+	h.cfg.logger().Error(mctx, "multiplexed session error", "server_addr", h.cfg.Addr, "error", err)
+}
+
+func (h *muxHandler) OnDisconnected(session *knet.IoSession) {
+	h.cfg.logger().Info(mctx, "multiplexed session disconnected", "server_addr", h.cfg.Addr)
+}
+
+// pingIdle keeps an idle session alive, closing it if the server stopped
+// answering.
+func pingIdle(session *knet.IoSession) error {
+	if session.GetIdleCount() > 2 {
+		return knet.ErrPeerDead
+	}
+
+	pkt := newPingPacket()
+	session.Send(mctx, pkt)
+	return nil
+}
@@ -0,0 +1,87 @@
+package cdbpool
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stn81/knet"
+)
+
+// blockingClient is a session whose dial waits for release.
+type blockingClient struct {
+	knet.Client
+	dialing chan struct{}
+	release chan struct{}
+	closed  chan struct{}
+}
+
+func (c *blockingClient) Dial(addr string) error {
+	close(c.dialing)
+	<-c.release
+	return nil
+}
+
+func (c *blockingClient) IsConnected() bool {
+	return true
+}
+
+func (c *blockingClient) Close() {
+	close(c.closed)
+}
+
+func newBlockingMuxPool() (*muxPool, *blockingClient) {
+	client := &blockingClient{
+		dialing: make(chan struct{}),
+		release: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+
+	cfg := NewConfig()
+	cfg.Multiplex = true
+	cfg.NewClient = func(cfg *Config) knet.Client {
+		client.Client = newTCPClient(cfg)
+		return client
+	}
+	return newMuxPool(cfg), client
+}
+
+func TestMuxDialContext(t *testing.T) {
+	p, client := newBlockingMuxPool()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.acquire(ctx); err != context.DeadlineExceeded {
+		t.Errorf("acquire: got err=%v, want %v", err, context.DeadlineExceeded)
+	}
+
+	close(client.release)
+	select {
+	case <-client.closed:
+	case <-time.After(time.Second):
+		t.Errorf("session of the abandoned dial not closed")
+	}
+}
+
+func TestMuxDialClosed(t *testing.T) {
+	p, client := newBlockingMuxPool()
+
+	acquired := make(chan error, 1)
+	go func() {
+		_, err := p.acquire(context.Background())
+		acquired <- err
+	}()
+
+	<-client.dialing
+	p.close()
+	close(client.release)
+
+	if err := <-acquired; err != errMuxClosed {
+		t.Errorf("acquire: got err=%v, want %v", err, errMuxClosed)
+	}
+	select {
+	case <-client.closed:
+	default:
+		t.Errorf("session dialed after close not closed")
+	}
+}
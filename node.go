@@ -0,0 +1,195 @@
+package cdbpool
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// latencyDecay is the weight of a new sample in the latency average.
+	latencyDecay = 0.2
+	// latencyPenalty is the latency sample recorded for a failed call.
+	latencyPenalty = time.Second
+)
+
+var errInvalidNodeWeight = errors.New("invalid DSN: node weight must be a positive integer")
+
+// NodeState is the health state of a cluster node.
+type NodeState int32
+
+const (
+	NodeHealthy NodeState = iota
+	NodeUnhealthy
+)
+
+func (s NodeState) String() string {
+	switch s {
+	case NodeHealthy:
+		return "healthy"
+	case NodeUnhealthy:
+		return "unhealthy"
+	default:
+		return "unknown"
+	}
+}
+
+// Node is a cdbpool server of a Cluster together with its connection pool.
+type Node struct {
+	Addr   string
+	Weight int // Weight used by the weighted balancer, 1 by default
+	DB     *sql.DB
+
+	inflight int64          // requests in flight, updated atomically
+	reads    *latencyWindow // latency of the successful reads, if not nil
+
+	mu        sync.Mutex
+	latency   float64 // moving average of the call latency, in nanoseconds
+	state     NodeState
+	failures  int // consecutive failed probes
+	successes int // consecutive successful probes
+	lastErr   error
+	lastCheck time.Time
+}
+
+// NodeStatus is a snapshot of the state of a Node.
+type NodeStatus struct {
+	Addr      string
+	State     NodeState
+	Failures  int // Consecutive failed probes
+	Successes int // Consecutive successful probes
+	LastError error
+	LastCheck time.Time
+	Weight    int
+	InFlight  int64
+	Latency   time.Duration
+	Stats     sql.DBStats
+}
+
+// parseNode parses a node of the DSN address list, given as addr or
+// addr#weight.
+func parseNode(s string) (*Node, error) {
+	node := &Node{
+		Addr:   s,
+		Weight: 1,
+	}
+
+	if i := strings.LastIndexByte(s, '#'); i >= 0 {
+		weight, err := strconv.Atoi(s[i+1:])
+		if err != nil || weight <= 0 {
+			return nil, errInvalidNodeWeight
+		}
+		node.Addr, node.Weight = s[:i], weight
+	}
+	return node, nil
+}
+
+// Healthy reports whether the node may be picked for new requests.
+func (n *Node) Healthy() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.state == NodeHealthy
+}
+
+// Status returns a snapshot of the node state.
+func (n *Node) Status() NodeStatus {
+	n.mu.Lock()
+	status := NodeStatus{
+		Addr:      n.Addr,
+		State:     n.state,
+		Failures:  n.failures,
+		Successes: n.successes,
+		LastError: n.lastErr,
+		LastCheck: n.lastCheck,
+		Weight:    n.Weight,
+		Latency:   time.Duration(n.latency),
+	}
+	n.mu.Unlock()
+
+	status.InFlight = n.InFlight()
+
+	status.Stats = n.DB.Stats()
+	return status
+}
+
+// report records the outcome of a health probe and returns the new state
+// of the node, and whether it changed.
+func (n *Node) report(err error, conf *HealthCheckConfig) (NodeState, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.lastErr = err
+	n.lastCheck = time.Now()
+
+	old := n.state
+	if err != nil {
+		n.failures++
+		n.successes = 0
+		if n.failures >= conf.FailThreshold {
+			n.state = NodeUnhealthy
+		}
+	} else {
+		n.successes++
+		n.failures = 0
+		if n.successes >= conf.SuccessThreshold {
+			n.state = NodeHealthy
+		}
+	}
+	return n.state, n.state != old
+}
+
+// InFlight returns the number of requests in flight on the node.
+func (n *Node) InFlight() int64 {
+	return atomic.LoadInt64(&n.inflight)
+}
+
+// Latency returns the moving average of the call latency of the node.
+func (n *Node) Latency() time.Duration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return time.Duration(n.latency)
+}
+
+// observe records the latency of a call. Failed calls are recorded with
+// at least latencyPenalty so that the balancers avoid the node.
+func (n *Node) observe(elapsed time.Duration, err error) {
+	if err != nil && elapsed < latencyPenalty {
+		elapsed = latencyPenalty
+	}
+
+	n.mu.Lock()
+	if n.latency == 0 {
+		n.latency = float64(elapsed)
+	} else {
+		n.latency += (float64(elapsed) - n.latency) * latencyDecay
+	}
+	n.mu.Unlock()
+}
+
+// hooks returns the connection hooks tracking the requests in flight and
+// the latency of the node, chained with the user hooks.
+func (n *Node) hooks(user *Hooks) *Hooks {
+	return &Hooks{
+		BeforeCall: func(ctx context.Context, req *CdbPoolRequest) {
+			atomic.AddInt64(&n.inflight, 1)
+			if user != nil && user.BeforeCall != nil {
+				user.BeforeCall(ctx, req)
+			}
+		},
+		AfterCall: func(ctx context.Context, req *CdbPoolRequest, resp *CdbPoolResponse, err error, elapsed time.Duration) {
+			atomic.AddInt64(&n.inflight, -1)
+			n.observe(elapsed, err)
+			if n.reads != nil && err == nil && req.GetCommand() == "ori_select" {
+				n.reads.add(elapsed)
+			}
+			if user != nil && user.AfterCall != nil {
+				user.AfterCall(ctx, req, resp, err, elapsed)
+			}
+		},
+	}
+}
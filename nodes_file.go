@@ -0,0 +1,135 @@
+package cdbpool
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stn81/log"
+	"gopkg.in/yaml.v2"
+)
+
+const defaultWatchInterval = 5 * time.Second
+
+// NodesFileWatcher polls a JSON or YAML file listing the nodes of a Cluster
+// and applies its changes with UpdateNodes. The file holds either a list of
+// nodes, or an object with a nodes list:
+//
+//	nodes:
+//	  - 10.0.0.1:9123
+//	  - 10.0.0.2:9123#2
+type NodesFileWatcher struct {
+	cluster  *Cluster
+	path     string
+	interval time.Duration
+
+	sum   [sha256.Size]byte // of the last file read
+	nodes []string
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// WatchNodesFile applies the nodes listed in path to cluster, then checks
+// the file for changes every interval, 5s if zero. Once watching, invalid
+// or missing files are logged and the nodes are left unchanged.
+func WatchNodesFile(cluster *Cluster, path string, interval time.Duration) (*NodesFileWatcher, error) {
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+
+	w := &NodesFileWatcher{
+		cluster:  cluster,
+		path:     path,
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+
+	if err := w.reload(); err != nil {
+		return nil, err
+	}
+
+	w.wg.Add(1)
+	go w.loop()
+	return w, nil
+}
+
+// Close stops watching the file.
+func (w *NodesFileWatcher) Close() {
+	close(w.stop)
+	w.wg.Wait()
+}
+
+func (w *NodesFileWatcher) loop() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if err := w.reload(); err != nil {
+				log.Error(mctx, "reload nodes file", "path", w.path, "error", err)
+			}
+		}
+	}
+}
+
+// reload applies the nodes of the file if its content changed since the
+// last call.
+func (w *NodesFileWatcher) reload() error {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(data)
+	if sum == w.sum {
+		return nil
+	}
+
+	nodes, err := parseNodesFile(w.path, data)
+	if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(nodes, w.nodes) {
+		if err = w.cluster.UpdateNodes(nodes); err != nil {
+			return err
+		}
+		log.Info(mctx, "nodes file applied", "path", w.path, "nodes", nodes)
+	}
+
+	w.sum, w.nodes = sum, nodes
+	return nil
+}
+
+// parseNodesFile decodes data as JSON if path has a .json extension, and
+// as YAML otherwise.
+func parseNodesFile(path string, data []byte) ([]string, error) {
+	unmarshal := yaml.Unmarshal
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		unmarshal = json.Unmarshal
+	}
+
+	var nodes []string
+	if err := unmarshal(data, &nodes); err == nil {
+		return nodes, nil
+	}
+
+	var file struct {
+		Nodes []string `json:"nodes" yaml:"nodes"`
+	}
+	if err := unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	return file.Nodes, nil
+}
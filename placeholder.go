@@ -0,0 +1,124 @@
+package cdbpool
+
+import "strings"
+
+// placeholder is a parameter marker of a query, either ? or a named
+// parameter such as :id or @id.
+type placeholder struct {
+	begin, end int    // offsets of the marker in the query
+	name       string // name of a named parameter, empty for ?
+}
+
+// scanPlaceholders returns the placeholders of query in order. Quoted
+// strings, backtick quoted identifiers and comments are skipped, as are
+// @@system variables. An unterminated string or comment ends the scan, the
+// parser reports it later.
+func scanPlaceholders(query string) []placeholder {
+	var params []placeholder
+
+	for i := 0; i < len(query); i++ {
+		if i = skipQuotedOrComment(query, i); i < 0 {
+			return params
+		}
+
+		switch c := query[i]; c {
+		case '?':
+			params = append(params, placeholder{begin: i, end: i + 1})
+		case ':', '@':
+			if i > 0 && (isIdentChar(query[i-1]) || query[i-1] == ':' || query[i-1] == '@') {
+				continue
+			}
+			if c == '@' && i+1 < len(query) && query[i+1] == '@' {
+				// @@system_variable
+				i++
+				for i+1 < len(query) && (isIdentChar(query[i+1]) || query[i+1] == '.') {
+					i++
+				}
+				continue
+			}
+			if i+1 >= len(query) || !isIdentStart(query[i+1]) {
+				continue
+			}
+			j := i + 1
+			for j < len(query) && isIdentChar(query[j]) {
+				j++
+			}
+			params = append(params, placeholder{begin: i, end: j, name: query[i+1 : j]})
+			i = j - 1
+		}
+	}
+	return params
+}
+
+// skipQuotedOrComment returns the offset of the last byte of the quoted
+// string, backtick quoted identifier or comment starting at query[i], i if
+// none starts there, or -1 if it is not terminated or is a comment ending
+// the query.
+func skipQuotedOrComment(query string, i int) int {
+	switch query[i] {
+	case '\'', '"', '`':
+		return skipQuoted(query, i)
+	case '#':
+		return skipLine(query, i)
+	case '-':
+		// "-- " starts a comment, "--1" is a double negation.
+		if i+2 < len(query) && query[i+1] == '-' && isSpace(query[i+2]) ||
+			i+2 == len(query) && query[i+1] == '-' {
+			return skipLine(query, i)
+		}
+	case '/':
+		if i+1 < len(query) && query[i+1] == '*' {
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return -1
+			}
+			return i + 2 + end + 1
+		}
+	}
+	return i
+}
+
+// skipQuoted returns the offset of the quote closing the string or
+// identifier which starts at query[i], or -1 if it is not terminated.
+func skipQuoted(query string, i int) int {
+	quote := query[i]
+	for i++; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if quote != '`' {
+				i++
+			}
+		case quote:
+			// A doubled quote is an escaped quote.
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+// skipLine returns the offset of the newline ending the line of query[i],
+// or -1 if it is the last line.
+func skipLine(query string, i int) int {
+	for ; i < len(query); i++ {
+		if query[i] == '\n' {
+			return i
+		}
+	}
+	return -1
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || '0' <= c && c <= '9'
+}
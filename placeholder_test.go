@@ -0,0 +1,127 @@
+package cdbpool
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"testing"
+)
+
+func TestScanPlaceholders(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"select * from t where a = ? and b = ?", []string{"?", "?"}},
+		{"select * from t where note = 'why?' and id = ?", []string{"?"}},
+		{`select * from t where note = "it\"s?" and id = ?`, []string{"?"}},
+		{"select * from t where note = 'it''s?' and id = ?", []string{"?"}},
+		{"select `a?` from t where id = ?", []string{"?"}},
+		{"select a from t -- why?\nwhere id = ?", []string{"?"}},
+		{"select a from t # why?\nwhere id = ?", []string{"?"}},
+		{"select a from t /* why? */ where id = ?", []string{"?"}},
+		{"select a from t where id = --?", []string{"?"}},
+		{"select a from t where id = :id and uid = @uid", []string{":id", "@uid"}},
+		{"select a from t where a = :a_1 or b = :a_1", []string{":a_1", ":a_1"}},
+		{"select @@version, a::b, x:y, ':name' from t where id = ?", []string{"?"}},
+		{"select a from t where note = 'unterminated ?", nil},
+	}
+
+	for _, test := range tests {
+		var got []string
+		for _, param := range scanPlaceholders(test.query) {
+			got = append(got, test.query[param.begin:param.end])
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("scan %q: got %q, want %q", test.query, got, test.want)
+		}
+	}
+}
+
+func TestInterpolateNamedParams(t *testing.T) {
+	tests := []struct {
+		query string
+		args  []driver.NamedValue
+		want  string
+		err   bool
+	}{
+		{
+			query: "select * from t where note = 'why?' and id = ?",
+			args:  []driver.NamedValue{{Ordinal: 1, Value: int64(1)}},
+			want:  "select * from t where note = 'why?' and id = 1",
+		},
+		{
+			query: "select * from t where a = :id or b = @id and c = :name",
+			args:  []driver.NamedValue{{Name: "name", Ordinal: 1, Value: "x"}, {Name: "id", Ordinal: 2, Value: int64(2)}},
+			want:  "select * from t where a = 2 or b = 2 and c = 'x'",
+		},
+		{
+			query: "select * from t where a = :id and b = ?",
+			args:  []driver.NamedValue{{Ordinal: 1, Value: int64(1)}, {Name: "id", Ordinal: 2, Value: int64(2)}},
+			want:  "select * from t where a = 2 and b = 1",
+		},
+		{
+			query: "select * from t where a = :id",
+			args:  []driver.NamedValue{{Name: "uid", Ordinal: 1, Value: int64(1)}},
+			err:   true,
+		},
+		{
+			query: "select * from t where a = :id",
+			args:  []driver.NamedValue{{Name: "id", Ordinal: 1, Value: int64(1)}, {Name: "uid", Ordinal: 2, Value: int64(1)}},
+			err:   true,
+		},
+		{
+			query: "select * from t where a = ? and b = ?",
+			args:  []driver.NamedValue{{Ordinal: 1, Value: int64(1)}},
+			err:   true,
+		},
+		{
+			query: "set @rownum := 0",
+			want:  "set @rownum := 0",
+		},
+		{
+			query: "select @rownum := @rownum + 1 as n, a from t where b = ?",
+			args:  []driver.NamedValue{{Ordinal: 1, Value: "x"}},
+			want:  "select @rownum := @rownum + 1 as n, a from t where b = 'x'",
+		},
+	}
+
+	for _, test := range tests {
+		stmt, err := newStmt(context.Background(), &Conn{Config: &Config{}}, test.query)
+		if err != nil {
+			t.Fatalf("newStmt: err=%v", err)
+		}
+
+		q, err := stmt.interpolateParams(test.args)
+		if test.err {
+			if err == nil {
+				t.Errorf("interpolate %q: got %q, want error", test.query, q)
+			}
+			continue
+		}
+		if err != nil || q != test.want {
+			t.Errorf("interpolate %q: got %q, err=%v, want %q", test.query, q, err, test.want)
+		}
+	}
+}
+
+func TestStmtNumInput(t *testing.T) {
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"select * from t where note = 'why?' and id = ?", 1},
+		{"select * from t where a = :id or b = :id", -1},
+		{"select * from t", 0},
+	}
+
+	for _, test := range tests {
+		stmt, err := newStmt(context.Background(), &Conn{Config: &Config{}}, test.query)
+		if err != nil {
+			t.Fatalf("newStmt: err=%v", err)
+		}
+		if got := stmt.NumInput(); got != test.want {
+			t.Errorf("NumInput(%q): got %d, want %d", test.query, got, test.want)
+		}
+	}
+}
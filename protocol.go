@@ -61,10 +61,14 @@ func newQueryPacket(id uint32, m proto.Message) *Packet {
 	return newPacket(id, CmdQuery, m)
 }
 
+// Session attributes holding the buffers of Decode.
 const (
 	KeyReadBuf   = "r_buf"
-	KeyWriteBuf  = "w_buf"
 	KeyDecodeBuf = "d_buf"
+
+	// Deprecated: Encode allocates the buffers of each packet.
+	KeyWriteBuf = "w_buf"
+	// Deprecated: Encode allocates the buffers of each packet.
 	KeyEncodeBuf = "e_buf"
 )
 
@@ -116,39 +120,24 @@ func (p *Protocol) Decode(session *knet.IoSession, reader io.Reader) (m knet.Mes
 	return
 }
 
+// Encode allocates the buffers of each packet, as the connections sharing a
+// multiplexed session send their requests concurrently.
 func (p *Protocol) Encode(session *knet.IoSession, m knet.Message) (data []byte, err error) {
 	var (
-		pkt       = m.(*Packet)
-		writeBuf  *bytes.Buffer
-		encodeBuf *proto.Buffer
-		ok        bool
+		pkt  = m.(*Packet)
+		body []byte
 	)
 
-	if writeBuf, ok = session.GetAttr(KeyWriteBuf).(*bytes.Buffer); !ok {
-		writeBuf = &bytes.Buffer{}
-		session.SetAttr(KeyWriteBuf, writeBuf)
-	}
-	writeBuf.Reset()
-
 	if pkt.Message != nil {
-		if encodeBuf, ok = session.GetAttr(KeyEncodeBuf).(*proto.Buffer); !ok {
-			encodeBuf = &proto.Buffer{}
-			session.SetAttr(KeyEncodeBuf, encodeBuf)
-		}
-		encodeBuf.Reset()
-
-		if err = encodeBuf.Marshal(pkt.Message); err != nil {
+		if body, err = proto.Marshal(pkt.Message); err != nil {
 			return
 		}
-
-		pkt.Header.BodyLen = uint32(len(encodeBuf.Bytes()))
+		pkt.Header.BodyLen = uint32(len(body))
 	}
 
+	writeBuf := bytes.NewBuffer(make([]byte, 0, binary.Size(&pkt.Header)+len(body)))
 	binary.Write(writeBuf, binary.BigEndian, &pkt.Header)
-
-	if encodeBuf != nil {
-		writeBuf.Write(encodeBuf.Bytes())
-	}
+	writeBuf.Write(body)
 
 	data = writeBuf.Bytes()
 	return
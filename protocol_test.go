@@ -0,0 +1,46 @@
+package cdbpool
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestProtocolEncodeConcurrently(t *testing.T) {
+	var (
+		p    = &Protocol{}
+		data = make([][]byte, 16)
+		wg   sync.WaitGroup
+	)
+
+	for i := range data {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pkt := newQueryPacket(uint32(i), &CdbPoolRequest{Logid: string(rune('a' + i))})
+			data[i], _ = p.Encode(nil, pkt)
+		}(i)
+	}
+	wg.Wait()
+
+	// Each packet keeps its own header and body once the others are encoded.
+	for i, b := range data {
+		var (
+			header Header
+			req    CdbPoolRequest
+			r      = bytes.NewReader(b)
+		)
+		if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+			t.Fatalf("packet %d: header error: %v", i, err)
+		}
+		if err := proto.Unmarshal(b[len(b)-r.Len():], &req); err != nil {
+			t.Fatalf("packet %d: body error: %v", i, err)
+		}
+		if header.ContextId != uint32(i) || int(header.BodyLen) != r.Len() || req.Logid != string(rune('a'+i)) {
+			t.Errorf("packet %d: got header %+v, logid %q", i, header, req.Logid)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package qb
+
+import (
+	"strings"
+
+	"github.com/stn81/cdbpool"
+)
+
+const (
+	FilterAnd = "and"
+	FilterOr  = "or"
+)
+
+// Cond is a single filter on a column, sent to the server as a CondPair.
+type Cond struct {
+	key       string
+	condition string
+	values    []interface{}
+}
+
+func newCond(key, condition string, values ...interface{}) Cond {
+	return Cond{
+		key:       key,
+		condition: condition,
+		values:    values,
+	}
+}
+
+// Eq filters rows where key = value.
+func Eq(key string, value interface{}) Cond { return newCond(key, "=", value) }
+
+// Ne filters rows where key != value.
+func Ne(key string, value interface{}) Cond { return newCond(key, "!=", value) }
+
+// Gt filters rows where key > value.
+func Gt(key string, value interface{}) Cond { return newCond(key, ">", value) }
+
+// Ge filters rows where key >= value.
+func Ge(key string, value interface{}) Cond { return newCond(key, ">=", value) }
+
+// Lt filters rows where key < value.
+func Lt(key string, value interface{}) Cond { return newCond(key, "<", value) }
+
+// Le filters rows where key <= value.
+func Le(key string, value interface{}) Cond { return newCond(key, "<=", value) }
+
+// Like filters rows where key like pattern.
+func Like(key string, pattern string) Cond { return newCond(key, "like", pattern) }
+
+// In filters rows where key is one of values.
+func In(key string, values ...interface{}) Cond { return newCond(key, "in", values...) }
+
+// pair encodes the condition. The value of an `in` condition is the
+// comma separated list of its values, string values being quoted.
+func (c Cond) pair() (*cdbpool.CondPair, error) {
+	p := &cdbpool.CondPair{
+		Key:       c.key,
+		Condition: c.condition,
+	}
+
+	if c.condition != "in" {
+		value, typ, err := cdbpool.EncodeValue(c.values[0])
+		if err != nil {
+			return nil, err
+		}
+		p.Value, p.Type = value, typ
+		return p, nil
+	}
+
+	if len(c.values) == 0 {
+		return nil, &cdbpool.SQLValidationError{
+			Clause: "where",
+			Detail: "`in` requires at least one value",
+			Err:    cdbpool.ErrInvalidSQL,
+		}
+	}
+
+	values := make([]string, len(c.values))
+	for i, v := range c.values {
+		value, typ, err := cdbpool.EncodeValue(v)
+		if err != nil {
+			return nil, err
+		}
+		if typ == cdbpool.ValueType_VALUE_TYPE_STRING || typ == cdbpool.ValueType_VALUE_TYPE_TIME {
+			value = cdbpool.QuoteString(value)
+		}
+		values[i], p.Type = value, typ
+	}
+	p.Value = strings.Join(values, ",")
+	return p, nil
+}
+
+func pairs(conds []Cond) ([]*cdbpool.CondPair, error) {
+	ps := make([]*cdbpool.CondPair, len(conds))
+	for i, c := range conds {
+		p, err := c.pair()
+		if err != nil {
+			return nil, err
+		}
+		ps[i] = p
+	}
+	return ps, nil
+}
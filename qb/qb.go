@@ -0,0 +1,473 @@
+// Package qb builds the structured cdbpool requests (select, insert, update
+// and delete) from typed filters, without going through SQL and the sql
+// parser.
+//
+// Requests are routed by the RouteInfo stored in the context, see
+// cdbpool.SetRoute. The *Conn variants run on a *sql.Conn, within the
+// transaction begun by its BeginTx if any.
+package qb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strconv"
+	"strings"
+
+	"github.com/stn81/cdbpool"
+)
+
+func errMissingWhere() error {
+	return &cdbpool.SQLValidationError{
+		Clause: "where",
+		Detail: "must have `where` conditions",
+		Err:    cdbpool.ErrInvalidSQL,
+	}
+}
+
+func errInvalid(clause, detail string) error {
+	return &cdbpool.SQLValidationError{
+		Clause: clause,
+		Detail: detail,
+		Err:    cdbpool.ErrInvalidSQL,
+	}
+}
+
+// where holds the conditions of a request, which are all joined by either
+// and or or: the server has no grouping of conditions.
+type where struct {
+	conds      []Cond
+	filterType string
+	mixed      bool
+}
+
+func (w *where) add(filterType string, conds []Cond) {
+	if len(conds) == 0 {
+		return
+	}
+	if len(w.conds) > 0 && w.filterType != filterType {
+		w.mixed = true
+	}
+	w.conds = append(w.conds, conds...)
+	w.filterType = filterType
+}
+
+// build returns the filter and the filter type of the request.
+func (w *where) build() ([]*cdbpool.CondPair, string, error) {
+	if len(w.conds) == 0 {
+		return nil, "", errMissingWhere()
+	}
+	if w.mixed {
+		return nil, "", errInvalid("where", "`Where` and `WhereAny` can not be mixed")
+	}
+
+	filter, err := pairs(w.conds)
+	if err != nil {
+		return nil, "", err
+	}
+	return filter, w.filterType, nil
+}
+
+type result struct {
+	lastInsertId int64
+	rowsAffected int64
+}
+
+func (r *result) LastInsertId() (int64, error) { return r.lastInsertId, nil }
+func (r *result) RowsAffected() (int64, error) { return r.rowsAffected, nil }
+
+// SelectBuilder builds a SelectRequest.
+type SelectBuilder struct {
+	table     string
+	columns   []string
+	where     where
+	orderBy   []string
+	limit     string
+	forUpdate bool
+	err       error
+}
+
+// Select starts a select on table. All columns are selected if none given.
+func Select(table string, columns ...string) *SelectBuilder {
+	return &SelectBuilder{
+		table:   table,
+		columns: columns,
+	}
+}
+
+// Where adds conditions which must all hold. It can not be combined with
+// WhereAny.
+func (b *SelectBuilder) Where(conds ...Cond) *SelectBuilder {
+	b.where.add(FilterAnd, conds)
+	return b
+}
+
+// WhereAny adds conditions of which at least one must hold. It can not be
+// combined with Where.
+func (b *SelectBuilder) WhereAny(conds ...Cond) *SelectBuilder {
+	b.where.add(FilterOr, conds)
+	return b
+}
+
+// OrderBy orders the rows by column, in descending order if desc is set.
+// Later calls order the rows with the same values of the previous columns.
+func (b *SelectBuilder) OrderBy(column string, desc bool) *SelectBuilder {
+	if !isIdent(column) {
+		if b.err == nil {
+			b.err = errInvalid("order by", "invalid column `"+column+"`")
+		}
+		return b
+	}
+
+	if desc {
+		column += " desc"
+	}
+	b.orderBy = append(b.orderBy, column)
+	return b
+}
+
+// Limit limits the number of rows returned.
+func (b *SelectBuilder) Limit(n int) *SelectBuilder {
+	b.limit = strconv.Itoa(n)
+	return b
+}
+
+// LimitOffset limits the number of rows returned, skipping the first offset rows.
+func (b *SelectBuilder) LimitOffset(offset, n int) *SelectBuilder {
+	b.limit = strconv.Itoa(offset) + ", " + strconv.Itoa(n)
+	return b
+}
+
+// ForUpdate locks the selected rows until the end of the transaction, so
+// that it only applies to QueryConn on a connection in a transaction.
+func (b *SelectBuilder) ForUpdate() *SelectBuilder {
+	b.forUpdate = true
+	return b
+}
+
+// Request builds the request. An empty dbName is filled in from the route.
+func (b *SelectBuilder) Request(dbName string) (*cdbpool.CdbPoolRequest, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	filter, filterType, err := b.where.build()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := b.columns
+	if len(columns) == 0 {
+		columns = []string{"*"}
+	}
+
+	req := &cdbpool.SelectRequest{
+		Dbname:     dbName,
+		Table:      b.table,
+		Columns:    columns,
+		Filter:     filter,
+		FilterType: filterType,
+		Orderby:    strings.Join(b.orderBy, ", "),
+		Limit:      b.limit,
+	}
+	if b.forUpdate {
+		req.Forupdate = 1
+	}
+
+	return &cdbpool.CdbPoolRequest{
+		Command: "select",
+		Req:     &cdbpool.CdbPoolRequest_SelectReq{SelectReq: req},
+	}, nil
+}
+
+// Query runs the select and returns the rows decoded by ValueType.
+func (b *SelectBuilder) Query(ctx context.Context, db *sql.DB) ([]map[string]interface{}, error) {
+	req, err := b.Request("")
+	if err != nil {
+		return nil, err
+	}
+	return cdbpool.QueryRecords(ctx, db, req)
+}
+
+// QueryConn runs the select on conn, see Query.
+func (b *SelectBuilder) QueryConn(ctx context.Context, conn *sql.Conn) ([]map[string]interface{}, error) {
+	req, err := b.Request("")
+	if err != nil {
+		return nil, err
+	}
+	return cdbpool.QueryRecordsConn(ctx, conn, req)
+}
+
+// InsertBuilder builds an InsertRequest.
+type InsertBuilder struct {
+	table  string
+	values []*cdbpool.KVPair
+	err    error
+}
+
+// Insert starts an insert into table.
+func Insert(table string) *InsertBuilder {
+	return &InsertBuilder{
+		table: table,
+	}
+}
+
+// Set sets the value of a column.
+func (b *InsertBuilder) Set(key string, value interface{}) *InsertBuilder {
+	b.values, b.err = appendKVPair(b.values, b.err, key, value)
+	return b
+}
+
+// Request builds the request. An empty dbName is filled in from the route.
+func (b *InsertBuilder) Request(dbName string) (*cdbpool.CdbPoolRequest, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	return &cdbpool.CdbPoolRequest{
+		Command: "insert",
+		Req: &cdbpool.CdbPoolRequest_InsertReq{
+			InsertReq: &cdbpool.InsertRequest{
+				Dbname: dbName,
+				Table:  b.table,
+				Record: &cdbpool.StoreRecord{Units: b.values},
+			},
+		},
+	}, nil
+}
+
+// Exec runs the insert.
+func (b *InsertBuilder) Exec(ctx context.Context, db *sql.DB) (sql.Result, error) {
+	req, err := b.Request("")
+	if err != nil {
+		return nil, err
+	}
+	return insertResult(cdbpool.Do(ctx, db, req))
+}
+
+// ExecConn runs the insert on conn, see Exec.
+func (b *InsertBuilder) ExecConn(ctx context.Context, conn *sql.Conn) (sql.Result, error) {
+	req, err := b.Request("")
+	if err != nil {
+		return nil, err
+	}
+	return insertResult(cdbpool.DoConn(ctx, conn, req))
+}
+
+func insertResult(resp *cdbpool.CdbPoolResponse, err error) (sql.Result, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	insertResp := resp.GetInsertResp()
+	if insertResp == nil {
+		return nil, driver.ErrBadConn
+	}
+	return &result{
+		lastInsertId: int64(insertResp.GetLastInsertid()),
+		rowsAffected: int64(insertResp.GetAffectRows()),
+	}, nil
+}
+
+// UpdateBuilder builds an UpdateRequest.
+type UpdateBuilder struct {
+	table  string
+	values []*cdbpool.KVPair
+	where  where
+	err    error
+}
+
+// Update starts an update of table.
+func Update(table string) *UpdateBuilder {
+	return &UpdateBuilder{
+		table: table,
+	}
+}
+
+// Set sets the new value of a column.
+func (b *UpdateBuilder) Set(key string, value interface{}) *UpdateBuilder {
+	b.values, b.err = appendKVPair(b.values, b.err, key, value)
+	return b
+}
+
+// Where adds conditions which must all hold. It can not be combined with
+// WhereAny.
+func (b *UpdateBuilder) Where(conds ...Cond) *UpdateBuilder {
+	b.where.add(FilterAnd, conds)
+	return b
+}
+
+// WhereAny adds conditions of which at least one must hold. It can not be
+// combined with Where.
+func (b *UpdateBuilder) WhereAny(conds ...Cond) *UpdateBuilder {
+	b.where.add(FilterOr, conds)
+	return b
+}
+
+// Request builds the request. An empty dbName is filled in from the route.
+func (b *UpdateBuilder) Request(dbName string) (*cdbpool.CdbPoolRequest, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	if len(b.values) == 0 {
+		return nil, errInvalid("set", "must set at least one column")
+	}
+
+	filter, filterType, err := b.where.build()
+	if err != nil {
+		return nil, err
+	}
+
+	return &cdbpool.CdbPoolRequest{
+		Command: "update",
+		Req: &cdbpool.CdbPoolRequest_UpdateReq{
+			UpdateReq: &cdbpool.UpdateRequest{
+				Dbname:     dbName,
+				Table:      b.table,
+				Records:    b.values,
+				Filter:     filter,
+				FilterType: filterType,
+			},
+		},
+	}, nil
+}
+
+// Exec runs the update.
+func (b *UpdateBuilder) Exec(ctx context.Context, db *sql.DB) (sql.Result, error) {
+	req, err := b.Request("")
+	if err != nil {
+		return nil, err
+	}
+	return updateResult(cdbpool.Do(ctx, db, req))
+}
+
+// ExecConn runs the update on conn, see Exec.
+func (b *UpdateBuilder) ExecConn(ctx context.Context, conn *sql.Conn) (sql.Result, error) {
+	req, err := b.Request("")
+	if err != nil {
+		return nil, err
+	}
+	return updateResult(cdbpool.DoConn(ctx, conn, req))
+}
+
+func updateResult(resp *cdbpool.CdbPoolResponse, err error) (sql.Result, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	updateResp := resp.GetUpdateResp()
+	if updateResp == nil {
+		return nil, driver.ErrBadConn
+	}
+	return &result{
+		lastInsertId: int64(updateResp.GetLastInsertid()),
+		rowsAffected: int64(updateResp.GetAffectRows()),
+	}, nil
+}
+
+// DeleteBuilder builds a DeleteRequest.
+type DeleteBuilder struct {
+	table string
+	where where
+}
+
+// Delete starts a delete from table.
+func Delete(table string) *DeleteBuilder {
+	return &DeleteBuilder{
+		table: table,
+	}
+}
+
+// Where adds conditions which must all hold. It can not be combined with
+// WhereAny.
+func (b *DeleteBuilder) Where(conds ...Cond) *DeleteBuilder {
+	b.where.add(FilterAnd, conds)
+	return b
+}
+
+// WhereAny adds conditions of which at least one must hold. It can not be
+// combined with Where.
+func (b *DeleteBuilder) WhereAny(conds ...Cond) *DeleteBuilder {
+	b.where.add(FilterOr, conds)
+	return b
+}
+
+// Request builds the request. An empty dbName is filled in from the route.
+func (b *DeleteBuilder) Request(dbName string) (*cdbpool.CdbPoolRequest, error) {
+	filter, filterType, err := b.where.build()
+	if err != nil {
+		return nil, err
+	}
+
+	return &cdbpool.CdbPoolRequest{
+		Command: "delete",
+		Req: &cdbpool.CdbPoolRequest_DeleteReq{
+			DeleteReq: &cdbpool.DeleteRequest{
+				Dbname:     dbName,
+				Table:      b.table,
+				Filter:     filter,
+				FilterType: filterType,
+			},
+		},
+	}, nil
+}
+
+// Exec runs the delete.
+func (b *DeleteBuilder) Exec(ctx context.Context, db *sql.DB) (sql.Result, error) {
+	req, err := b.Request("")
+	if err != nil {
+		return nil, err
+	}
+	return deleteResult(cdbpool.Do(ctx, db, req))
+}
+
+// ExecConn runs the delete on conn, see Exec.
+func (b *DeleteBuilder) ExecConn(ctx context.Context, conn *sql.Conn) (sql.Result, error) {
+	req, err := b.Request("")
+	if err != nil {
+		return nil, err
+	}
+	return deleteResult(cdbpool.DoConn(ctx, conn, req))
+}
+
+func deleteResult(resp *cdbpool.CdbPoolResponse, err error) (sql.Result, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	deleteResp := resp.GetDeleteResp()
+	if deleteResp == nil {
+		return nil, driver.ErrBadConn
+	}
+	return &result{
+		rowsAffected: int64(deleteResp.GetAffectRows()),
+	}, nil
+}
+
+func appendKVPair(kvs []*cdbpool.KVPair, err error, key string, value interface{}) ([]*cdbpool.KVPair, error) {
+	if err != nil {
+		return kvs, err
+	}
+
+	v, typ, err := cdbpool.EncodeValue(value)
+	if err != nil {
+		return kvs, err
+	}
+
+	return append(kvs, &cdbpool.KVPair{Key: key, Value: v, Type: typ}), nil
+}
+
+// isIdent reports whether s is a plain, optionally qualified, column name.
+func isIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '_' && c != '.' && !('a' <= c && c <= 'z') && !('A' <= c && c <= 'Z') && !('0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
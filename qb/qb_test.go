@@ -0,0 +1,170 @@
+package qb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stn81/cdbpool"
+	"github.com/stn81/cdbpool/cdbpooltest"
+)
+
+func TestSelectRequest(t *testing.T) {
+	req, err := Select("users", "id", "name").
+		WhereAny(Eq("id", 1), In("name", "a", "b'c")).
+		OrderBy("id", true).
+		OrderBy("name", false).
+		LimitOffset(10, 20).
+		Request("test")
+	if err != nil {
+		t.Fatalf("Request() error: %v", err)
+	}
+
+	selectReq := req.GetSelectReq()
+	if req.Command != "select" || selectReq.Table != "users" || selectReq.FilterType != FilterOr {
+		t.Fatalf("unexpected request: %v", req)
+	}
+
+	if len(selectReq.Filter) != 2 {
+		t.Fatalf("got %d filters, want 2", len(selectReq.Filter))
+	}
+
+	id := selectReq.Filter[0]
+	if id.Key != "id" || id.Value != "1" || id.Condition != "=" || id.Type != cdbpool.ValueType_VALUE_TYPE_INTEGER {
+		t.Errorf("unexpected id filter: %v", id)
+	}
+
+	name := selectReq.Filter[1]
+	if name.Value != `'a','b\'c'` || name.Condition != "in" {
+		t.Errorf("unexpected name filter: %v", name)
+	}
+
+	if selectReq.Orderby != "id desc, name" {
+		t.Errorf("order by: got %q, want \"id desc, name\"", selectReq.Orderby)
+	}
+
+	if selectReq.Limit != "10, 20" {
+		t.Errorf("limit: got %q, want \"10, 20\"", selectReq.Limit)
+	}
+}
+
+func TestMissingWhere(t *testing.T) {
+	if _, err := Delete("users").Request("test"); !errors.Is(err, cdbpool.ErrInvalidSQL) {
+		t.Errorf("delete without where: got %v, want ErrInvalidSQL", err)
+	}
+
+	if _, err := Update("users").Set("name", nil).Where(Eq("id", 1)).Request("test"); !errors.Is(err, cdbpool.ErrNullValue) {
+		t.Errorf("update with NULL: got %v, want ErrNullValue", err)
+	}
+}
+
+func TestInvalidRequest(t *testing.T) {
+	if _, err := Select("users").Where(Eq("id", 1)).WhereAny(Eq("name", "a")).Request("test"); !errors.Is(err, cdbpool.ErrInvalidSQL) {
+		t.Errorf("select with Where and WhereAny: got %v, want ErrInvalidSQL", err)
+	}
+
+	if _, err := Delete("users").WhereAny(Eq("id", 1)).Where(Eq("name", "a")).Request("test"); !errors.Is(err, cdbpool.ErrInvalidSQL) {
+		t.Errorf("delete with WhereAny and Where: got %v, want ErrInvalidSQL", err)
+	}
+
+	if _, err := Update("users").Where(Eq("id", 1)).Request("test"); !errors.Is(err, cdbpool.ErrInvalidSQL) {
+		t.Errorf("update without set: got %v, want ErrInvalidSQL", err)
+	}
+
+	if _, err := Select("users").Where(Eq("id", 1)).OrderBy("id; drop table users", false).Request("test"); !errors.Is(err, cdbpool.ErrInvalidSQL) {
+		t.Errorf("order by invalid column: got %v, want ErrInvalidSQL", err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	srv := cdbpooltest.NewServer()
+	defer srv.Close()
+
+	db, err := sql.Open("cdbpool", srv.DSN("test"))
+	if err != nil {
+		t.Fatalf("sql.Open() error: %v", err)
+	}
+	defer db.Close()
+
+	ctx := cdbpool.SetRoute(context.Background(), "test", 1, false)
+
+	srv.Handle("select", cdbpooltest.Select(cdbpooltest.Records(
+		[]string{"id", "name"},
+		[]interface{}{int64(1), "foo"},
+	)))
+	records, err := Select("users", "id", "name").Where(Eq("id", 1)).Query(ctx, db)
+	if err != nil {
+		t.Fatalf("Query() error: %v", err)
+	}
+	if len(records) != 1 || records[0]["id"] != int64(1) || records[0]["name"] != "foo" {
+		t.Errorf("Query(): got %v", records)
+	}
+	if req := srv.LastRequest(); req.Command != "select" || req.GetSelectReq().GetTable() != "users" {
+		t.Errorf("unexpected request: %v", req)
+	}
+
+	srv.Handle("update", cdbpooltest.Update(3))
+	res, err := Update("users").Set("name", "bar").Where(Eq("id", 1)).Exec(ctx, db)
+	if err != nil {
+		t.Fatalf("update Exec() error: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n != 3 {
+		t.Errorf("update RowsAffected(): got %v, want 3", n)
+	}
+
+	srv.Handle("insert", cdbpooltest.OK())
+	if _, err = Insert("users").Set("name", "bar").Exec(ctx, db); err != driver.ErrBadConn {
+		t.Errorf("insert without response: got %v, want driver.ErrBadConn", err)
+	}
+
+	// A reused request is sent unchanged.
+	req, err := Delete("users").Where(Eq("id", 1)).Request("")
+	if err != nil {
+		t.Fatalf("Request() error: %v", err)
+	}
+	srv.Handle("delete", cdbpooltest.Delete(1))
+	for i := 0; i < 2; i++ {
+		if _, err = cdbpool.Do(ctx, db, req); err != nil {
+			t.Fatalf("Do() error: %v", err)
+		}
+		if logid := srv.LastRequest().GetLogid(); !strings.HasPrefix(logid, "test.delete.") || strings.Count(logid, ".") != 2 {
+			t.Errorf("Do() #%d: got logid %q, want test.delete.<seq>", i, logid)
+		}
+	}
+	if req.GetLogid() != "" || req.GetBigid() != 0 || req.GetDeleteReq().GetDbname() != "" {
+		t.Errorf("Do() modified the request: %v", req)
+	}
+
+	// A select for update runs in the transaction of the connection, which
+	// routes it.
+	srv.Handle("transfer", cdbpooltest.OK())
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("Conn() error: %v", err)
+	}
+	defer conn.Close()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("BeginTx() error: %v", err)
+	}
+	if _, err = Select("users").Where(Eq("id", 1)).ForUpdate().QueryConn(context.Background(), conn); err != nil {
+		t.Fatalf("QueryConn() error: %v", err)
+	}
+	if req := srv.LastRequest(); req.GetBigid() != 1 || req.GetSelectReq().GetForupdate() != 1 {
+		t.Errorf("select for update in transaction: got %v", req)
+	}
+	if _, err = Delete("users").Where(Eq("id", 1)).ExecConn(context.Background(), conn); err != nil {
+		t.Errorf("ExecConn() error: %v", err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatalf("Commit() error: %v", err)
+	}
+
+	if _, err = Delete("users").Where(Eq("id", 1)).ExecConn(context.Background(), conn); !errors.Is(err, cdbpool.ErrMissingRouteInfo) {
+		t.Errorf("ExecConn() after commit: got %v, want %v", err, cdbpool.ErrMissingRouteInfo)
+	}
+}
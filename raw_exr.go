@@ -0,0 +1,168 @@
+package cdbpool
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stn81/bigid"
+	"github.com/stn81/kate/utils"
+)
+
+type rawExecutor struct {
+	*RouteInfo
+	ctx context.Context
+	dbc *Conn
+	req *CdbPoolRequest // filled in by Run, a copy of the caller's request
+}
+
+func (exr *rawExecutor) Run() (resp *CdbPoolResponse, err error) {
+	if exr.dbc.client == nil || !exr.dbc.client.IsConnected() {
+		return nil, driver.ErrBadConn
+	}
+
+	if exr.DBName == "" {
+		exr.DBName = exr.dbc.DBName
+	}
+
+	req := exr.req
+	req.Bigid = exr.BigId
+	req.RequestOfflineMysql = exr.Offline
+	req.NeedSqlInfo = true
+	if req.Logid == "" {
+		req.Logid = fmt.Sprintf("%s.%s", exr.DBName, req.Command)
+	}
+	exr.setDBName()
+
+	if resp, err = exr.dbc.call(exr.ctx, req); err != nil {
+		exr.dbc.logger().Error(exr.ctx, "db.do", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
+		return nil, driver.ErrBadConn
+	}
+
+	if ResultCode(resp.GetError()) != ResultCode_RC_SUCCESS {
+		sqlInfo := resp.GetSqlInfo()
+		if sqlInfo == nil {
+			sqlInfo = &MysqlInfo{
+				Sql:    req.Command,
+				Vsid:   utils.GetInt32(bigid.GetVSId(exr.BigId)),
+				Dbname: exr.DBName,
+			}
+		}
+		err = NewDBError(resp.GetError(), resp.GetErrMsg(), sqlInfo)
+		exr.dbc.logger().Error(exr.ctx, "db.do", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
+		return nil, err
+	}
+	return
+}
+
+// setDBName fills in the database name of the request body if the caller
+// left it empty.
+func (exr *rawExecutor) setDBName() {
+	switch r := exr.req.Req.(type) {
+	case *CdbPoolRequest_SelectReq:
+		if r.SelectReq.Dbname == "" {
+			r.SelectReq.Dbname = exr.DBName
+		}
+	case *CdbPoolRequest_InsertReq:
+		if r.InsertReq.Dbname == "" {
+			r.InsertReq.Dbname = exr.DBName
+		}
+	case *CdbPoolRequest_UpdateReq:
+		if r.UpdateReq.Dbname == "" {
+			r.UpdateReq.Dbname = exr.DBName
+		}
+	case *CdbPoolRequest_DeleteReq:
+		if r.DeleteReq.Dbname == "" {
+			r.DeleteReq.Dbname = exr.DBName
+		}
+	case *CdbPoolRequest_MulinsertReq:
+		if r.MulinsertReq.Dbname == "" {
+			r.MulinsertReq.Dbname = exr.DBName
+		}
+	}
+}
+
+// Do sends a pre-built request on a connection taken from db. The bigid,
+// offline flag and empty database names are filled in from the RouteInfo
+// stored in ctx. A failed ResultCode is returned as a *DBError. req itself
+// is not modified, so that it can be reused.
+func Do(ctx context.Context, db *sql.DB, req *CdbPoolRequest) (resp *CdbPoolResponse, err error) {
+	err = rawConn(ctx, db, func(dbc *Conn) error {
+		resp, err = do(ctx, dbc, req)
+		return err
+	})
+	return
+}
+
+// DoConn sends a pre-built request like Do, on conn. The request runs in
+// the transaction begun by conn.BeginTx if any, whose route is used when
+// ctx has none.
+func DoConn(ctx context.Context, conn *sql.Conn, req *CdbPoolRequest) (resp *CdbPoolResponse, err error) {
+	err = conn.Raw(func(driverConn interface{}) error {
+		resp, err = do(ctx, driverConn.(*Conn), req)
+		return err
+	})
+	return
+}
+
+// QueryRecords sends a pre-built select request like Do, and returns the
+// records of the response decoded by ValueType.
+func QueryRecords(ctx context.Context, db *sql.DB, req *CdbPoolRequest) (records []map[string]interface{}, err error) {
+	err = rawConn(ctx, db, func(dbc *Conn) error {
+		records, err = queryRecords(ctx, dbc, req)
+		return err
+	})
+	return
+}
+
+// QueryRecordsConn sends a pre-built select request like DoConn, and
+// returns the records of the response decoded by ValueType.
+func QueryRecordsConn(ctx context.Context, conn *sql.Conn, req *CdbPoolRequest) (records []map[string]interface{}, err error) {
+	err = conn.Raw(func(driverConn interface{}) error {
+		records, err = queryRecords(ctx, driverConn.(*Conn), req)
+		return err
+	})
+	return
+}
+
+// do runs a copy of req on dbc, routed by ctx or by the transaction of dbc.
+func do(ctx context.Context, dbc *Conn, req *CdbPoolRequest) (*CdbPoolResponse, error) {
+	route := GetRoute(ctx)
+	if route == nil && dbc.ctx != nil {
+		route = GetRoute(dbc.ctx)
+	}
+	if route == nil {
+		return nil, ErrMissingRouteInfo
+	}
+
+	exr := &rawExecutor{
+		RouteInfo: &RouteInfo{DBName: route.DBName, BigId: route.BigId, Offline: route.Offline},
+		ctx:       ctx,
+		dbc:       dbc,
+		req:       proto.Clone(req).(*CdbPoolRequest),
+	}
+	return exr.Run()
+}
+
+func queryRecords(ctx context.Context, dbc *Conn, req *CdbPoolRequest) ([]map[string]interface{}, error) {
+	resp, err := do(ctx, dbc, req)
+	if err != nil {
+		return nil, err
+	}
+
+	recs := resp.GetSelectResp().GetRecords()
+	if showResp := resp.GetOriShowResp(); showResp != nil {
+		recs = showResp.GetRecords()
+	}
+
+	records := make([]map[string]interface{}, len(recs))
+	for i, r := range recs {
+		records[i] = make(map[string]interface{}, len(r.Units))
+		for _, kv := range r.Units {
+			records[i][kv.Key] = decodeValue(dbc.Config, kv)
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,48 @@
+package cdbpool
+
+import (
+	"context"
+	"database/sql/driver"
+)
+
+type reloadExecutor struct {
+	ctx    context.Context
+	dbc    *Conn
+	reload string
+}
+
+func (exr *reloadExecutor) Run() (err error) {
+	if exr.dbc.client == nil || !exr.dbc.client.IsConnected() {
+		return driver.ErrBadConn
+	}
+
+	var (
+		req  *CdbPoolRequest
+		resp *CdbPoolResponse
+	)
+
+	req = &CdbPoolRequest{
+		Logid:       "server.reload_mysql",
+		Command:     "reload_mysql",
+		NeedSqlInfo: true,
+		Req: &CdbPoolRequest_ReloadMysqlReq{
+			&ReloadMysqlRequest{
+				Reload: exr.reload,
+			},
+		},
+	}
+
+	if resp, err = exr.dbc.call(exr.ctx, req); err != nil {
+		exr.dbc.logger().Error(exr.ctx, "db.reload_mysql", "logid", req.Logid, "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
+		return driver.ErrBadConn
+	}
+
+	if ResultCode(resp.GetError()) != ResultCode_RC_SUCCESS {
+		err = NewDBError(resp.GetError(), resp.GetErrMsg(), resp.GetSqlInfo())
+		exr.dbc.logger().Error(exr.ctx, "db.reload_mysql", "logid", req.Logid, "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
+		return
+	}
+
+	exr.dbc.logger().Info(exr.ctx, "db.reload_mysql", "logid", req.Logid, "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "reload", exr.reload)
+	return
+}
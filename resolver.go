@@ -0,0 +1,201 @@
+package cdbpool
+
+import (
+	"database/sql/driver"
+	"strconv"
+	"strings"
+
+	"github.com/stn81/bigid"
+	"github.com/stn81/sqlparser"
+)
+
+const defaultShardKey = "id"
+
+// RouteResolver derives the bigId of a statement from its parsed AST. It
+// returns false if the bigId can not be derived.
+type RouteResolver interface {
+	ResolveBigId(statement sqlparser.Statement) (uint64, bool)
+}
+
+// varsRouteResolver is a RouteResolver which can read the placeholders of
+// a cached statement template. The statements of other resolvers are
+// parsed with their arguments instead.
+type varsRouteResolver interface {
+	resolveBigIdVars(statement sqlparser.Statement, vars bindVars) (uint64, bool)
+}
+
+// ShardKeyResolver is a RouteResolver reading the bigId from the shard key
+// column of the table: an equality with a literal in the WHERE clause of
+// SELECT, UPDATE and DELETE, or the column value of INSERT, whose rows must
+// all belong to the same vsid.
+type ShardKeyResolver struct {
+	DefaultKey string            // Shard key of the tables not in Keys, "id" if empty
+	Keys       map[string]string // Shard key per table
+}
+
+// NewShardKeyResolver returns a ShardKeyResolver with the given shard keys
+// per table, using "id" for the other tables.
+func NewShardKeyResolver(keys map[string]string) *ShardKeyResolver {
+	return &ShardKeyResolver{
+		DefaultKey: defaultShardKey,
+		Keys:       keys,
+	}
+}
+
+func (r *ShardKeyResolver) key(table string) string {
+	if key, ok := r.Keys[table]; ok {
+		return key
+	}
+	if r.DefaultKey != "" {
+		return r.DefaultKey
+	}
+	return defaultShardKey
+}
+
+func (r *ShardKeyResolver) ResolveBigId(statement sqlparser.Statement) (uint64, bool) {
+	return r.resolveBigIdVars(statement, nil)
+}
+
+func (r *ShardKeyResolver) resolveBigIdVars(statement sqlparser.Statement, vars bindVars) (uint64, bool) {
+	switch ast := statement.(type) {
+	case *sqlparser.Select:
+		if len(ast.From) != 1 {
+			return 0, false
+		}
+		table, ok := ast.From[0].(*sqlparser.AliasedTableExpr)
+		if !ok {
+			return 0, false
+		}
+		name, ok := table.Expr.(*sqlparser.TableName)
+		if !ok || ast.Where == nil {
+			return 0, false
+		}
+		return r.resolveWhere(name, string(table.As), ast.Where.Expr, vars)
+	case *sqlparser.Update:
+		if ast.Where == nil {
+			return 0, false
+		}
+		return r.resolveWhere(ast.Table, "", ast.Where.Expr, vars)
+	case *sqlparser.Delete:
+		if ast.Where == nil {
+			return 0, false
+		}
+		return r.resolveWhere(ast.Table, "", ast.Where.Expr, vars)
+	case *sqlparser.Insert:
+		return r.resolveInsert(ast, vars)
+	}
+	return 0, false
+}
+
+func (r *ShardKeyResolver) resolveWhere(table *sqlparser.TableName, alias string, expr sqlparser.BoolExpr, vars bindVars) (uint64, bool) {
+	var (
+		name = string(table.Name)
+		key  = r.key(name)
+	)
+
+	switch e := expr.(type) {
+	case *sqlparser.AndExpr:
+		if bigId, ok := r.resolveWhere(table, alias, e.Left, vars); ok {
+			return bigId, true
+		}
+		return r.resolveWhere(table, alias, e.Right, vars)
+	case *sqlparser.ParenBoolExpr:
+		return r.resolveWhere(table, alias, e.Expr, vars)
+	case *sqlparser.ComparisonExpr:
+		if e.Operator != "=" {
+			return 0, false
+		}
+
+		col, val := e.Left, e.Right
+		if _, ok := col.(*sqlparser.ColName); !ok {
+			col, val = val, col
+		}
+
+		c, ok := col.(*sqlparser.ColName)
+		if !ok || !strings.EqualFold(string(c.Name), key) {
+			return 0, false
+		}
+		if q := string(c.Qualifier); q != "" && q != name && q != alias {
+			return 0, false
+		}
+		return literalBigId(val, vars)
+	}
+	return 0, false
+}
+
+func (r *ShardKeyResolver) resolveInsert(ast *sqlparser.Insert, vars bindVars) (uint64, bool) {
+	var (
+		key   = r.key(string(ast.Table.Name))
+		index = -1
+	)
+
+	for i, column := range ast.Columns {
+		if expr, ok := column.(*sqlparser.NonStarExpr); ok {
+			if c, ok := expr.Expr.(*sqlparser.ColName); ok && strings.EqualFold(string(c.Name), key) {
+				index = i
+				break
+			}
+		}
+	}
+
+	values, ok := ast.Rows.(sqlparser.Values)
+	if index < 0 || !ok || len(values) == 0 {
+		return 0, false
+	}
+
+	var bigId uint64
+	for i, row := range values {
+		tuple, ok := row.(sqlparser.ValTuple)
+		if !ok || index >= len(tuple) {
+			return 0, false
+		}
+
+		id, ok := literalBigId(tuple[index], vars)
+		if !ok {
+			return 0, false
+		}
+
+		if i == 0 {
+			bigId = id
+		} else if bigid.GetVSId(id) != bigid.GetVSId(bigId) {
+			return 0, false
+		}
+	}
+	return bigId, true
+}
+
+func literalBigId(val sqlparser.ValExpr, vars bindVars) (uint64, bool) {
+	v, ok := vars.literal(val)
+	if !ok {
+		return 0, false
+	}
+
+	if valuer, ok := v.(driver.Valuer); ok {
+		var err error
+		if v, err = valuer.Value(); err != nil {
+			return 0, false
+		}
+	}
+
+	switch x := v.(type) {
+	case int:
+		return uint64(x), x >= 0
+	case int32:
+		return uint64(x), x >= 0
+	case int64:
+		return uint64(x), x >= 0
+	case uint:
+		return uint64(x), true
+	case uint32:
+		return uint64(x), true
+	case uint64:
+		return x, true
+	case string:
+		bigId, err := strconv.ParseUint(x, 10, 64)
+		return bigId, err == nil
+	case []byte:
+		bigId, err := strconv.ParseUint(string(x), 10, 64)
+		return bigId, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,46 @@
+package cdbpool
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stn81/bigid"
+	"github.com/stn81/sqlparser"
+)
+
+func TestShardKeyResolver(t *testing.T) {
+	var (
+		r     = NewShardKeyResolver(map[string]string{"orders": "user_id"})
+		id1   = bigid.New(1)
+		id2   = bigid.New(2)
+		tests = []struct {
+			query string
+			bigId uint64
+			ok    bool
+		}{
+			{fmt.Sprintf("select * from users where id = %d", id1), id1, true},
+			{fmt.Sprintf("select * from users where name = 'x' and id = '%d'", id1), id1, true},
+			{fmt.Sprintf("select * from orders where id = %d", id1), 0, false},
+			{fmt.Sprintf("select * from orders where status = 1 and user_id = %d", id2), id2, true},
+			{fmt.Sprintf("update orders set status = 2 where user_id = %d", id2), id2, true},
+			{fmt.Sprintf("delete from users where id = %d", id1), id1, true},
+			{"delete from users where name = 'x'", 0, false},
+			{fmt.Sprintf("insert into orders(user_id, status) values(%d, 1)", id2), id2, true},
+			{fmt.Sprintf("insert into orders(user_id, status) values(%d, 1), (%d, 2)", id2, id2+1), id2, true},
+			{fmt.Sprintf("insert into orders(user_id, status) values(%d, 1), (%d, 2)", id1, id2), 0, false},
+			{"insert into orders(id, status) values(1, 1)", 0, false},
+		}
+	)
+
+	for _, test := range tests {
+		statement, err := sqlparser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("parse %q: err=%v", test.query, err)
+		}
+
+		bigId, ok := r.ResolveBigId(statement)
+		if ok != test.ok || bigId != test.bigId {
+			t.Errorf("ResolveBigId(%q): got %v, %v, want %v, %v", test.query, bigId, ok, test.bigId, test.ok)
+		}
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 
 	"github.com/stn81/bigid"
-	"github.com/stn81/log"
 	"github.com/stn81/kate/utils"
 )
 
@@ -42,7 +41,7 @@ func (exr *rollbackExecutor) Run() (err error) {
 	}
 
 	if resp, err = exr.dbc.call(exr.ctx, req); err != nil {
-		log.Error(exr.ctx, "db.transaction.rollback", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
+		exr.dbc.logger().Error(exr.ctx, "db.transaction.rollback", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
 		return driver.ErrBadConn
 	}
 
@@ -56,7 +55,7 @@ func (exr *rollbackExecutor) Run() (err error) {
 			}
 		}
 		err = NewDBError(resp.GetError(), resp.GetErrMsg(), sqlInfo)
-		log.Error(exr.ctx, "db.transaction.rollback", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
+		exr.dbc.logger().Error(exr.ctx, "db.transaction.rollback", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
 		return
 	}
 	return
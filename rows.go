@@ -3,14 +3,40 @@ package cdbpool
 import (
 	"database/sql/driver"
 	"io"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+const (
+	timeFormat     = "2006-01-02 15:04:05.999999999"
+	dateFormat     = "2006-01-02"
+	zeroTimeString = "0000-00-00 00:00:00"
+	zeroDateString = "0000-00-00"
+)
+
+var (
+	scanTypeInt64   = reflect.TypeOf(int64(0))
+	scanTypeFloat64 = reflect.TypeOf(float64(0))
+	scanTypeString  = reflect.TypeOf("")
+	scanTypeTime    = reflect.TypeOf(time.Time{})
 )
 
 type Rows struct {
+	cfg     *Config
 	records []*StoreRecord
 	columns []string
+	types   []ValueType
 	next    int
 }
 
+func newRows(cfg *Config, records []*StoreRecord) *Rows {
+	return &Rows{
+		cfg:     cfg,
+		records: records,
+	}
+}
+
 // Columns returns the names of the columns. The number of
 // columns of the result is inferred from the length of the
 // slice. If a particular column name isn't known, an empty
@@ -51,7 +77,112 @@ func (rows *Rows) Next(dest []driver.Value) (err error) {
 	rows.next++
 
 	for i, kv := range r.Units {
-		dest[i] = kv.Value
+		dest[i] = decodeValue(rows.cfg, kv)
 	}
 	return nil
 }
+
+// ColumnTypeScanType returns the value type that can be used to scan types into.
+func (rows *Rows) ColumnTypeScanType(index int) reflect.Type {
+	switch rows.columnType(index) {
+	case ValueType_VALUE_TYPE_INTEGER:
+		return scanTypeInt64
+	case ValueType_VALUE_TYPE_FLOAT:
+		return scanTypeFloat64
+	case ValueType_VALUE_TYPE_TIME:
+		return scanTypeTime
+	default:
+		return scanTypeString
+	}
+}
+
+// ColumnTypeDatabaseTypeName returns the database system type name.
+func (rows *Rows) ColumnTypeDatabaseTypeName(index int) string {
+	switch rows.columnType(index) {
+	case ValueType_VALUE_TYPE_INTEGER:
+		return "BIGINT"
+	case ValueType_VALUE_TYPE_FLOAT:
+		return "DOUBLE"
+	case ValueType_VALUE_TYPE_STRING:
+		return "VARCHAR"
+	case ValueType_VALUE_TYPE_TIME:
+		return "DATETIME"
+	default:
+		return ""
+	}
+}
+
+// columnType returns the first known type of the column, since NULL
+// values in the leading rows are sent without a type.
+func (rows *Rows) columnType(index int) ValueType {
+	if rows.types == nil {
+		rows.types = make([]ValueType, len(rows.Columns()))
+		for i := range rows.types {
+			for _, r := range rows.records {
+				if i < len(r.Units) && r.Units[i].Type != ValueType_VALUE_TYPE_INVALID {
+					rows.types[i] = r.Units[i].Type
+					break
+				}
+			}
+		}
+	}
+
+	if index < 0 || index >= len(rows.types) {
+		return ValueType_VALUE_TYPE_INVALID
+	}
+	return rows.types[index]
+}
+
+// decodeValue converts the string value sent by the server into the
+// driver.Value matching its ValueType. Values which can not be decoded
+// are returned as the raw string.
+//
+// TIME values are parsed in cfg.Loc, and left as strings with cfg.RawTime.
+//
+// SQL NULL is sent as an empty value: typed numeric and time columns
+// can not be empty otherwise, and untyped (VALUE_TYPE_INVALID) empty
+// values are "" unless cfg.EmptyAsNull is set.
+func decodeValue(cfg *Config, kv *KVPair) driver.Value {
+	if kv.Value == "" {
+		switch kv.Type {
+		case ValueType_VALUE_TYPE_STRING:
+			return kv.Value
+		case ValueType_VALUE_TYPE_INVALID, ValueType_VALUE_TYPE_UNKNOWN:
+			if cfg == nil || !cfg.EmptyAsNull {
+				return kv.Value
+			}
+		}
+		return nil
+	}
+
+	switch kv.Type {
+	case ValueType_VALUE_TYPE_INTEGER:
+		if v, err := strconv.ParseInt(kv.Value, 10, 64); err == nil {
+			return v
+		}
+	case ValueType_VALUE_TYPE_FLOAT:
+		if v, err := strconv.ParseFloat(kv.Value, 64); err == nil {
+			return v
+		}
+	case ValueType_VALUE_TYPE_TIME:
+		if cfg != nil && cfg.RawTime {
+			break
+		}
+		if v, err := parseTime(kv.Value, cfg.location()); err == nil {
+			return v
+		}
+	}
+	return kv.Value
+}
+
+func parseTime(s string, loc *time.Location) (time.Time, error) {
+	switch s {
+	case zeroTimeString, zeroDateString:
+		return time.Time{}, nil
+	}
+
+	if len(s) == len(dateFormat) {
+		return time.ParseInLocation(dateFormat, s, loc)
+	}
+	return time.ParseInLocation(timeFormat, s, loc)
+}
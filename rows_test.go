@@ -0,0 +1,122 @@
+package cdbpool
+
+import (
+	"database/sql/driver"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRowsNextTyped(t *testing.T) {
+	rows := &Rows{
+		records: []*StoreRecord{
+			{
+				Units: []*KVPair{
+					{Key: "id", Value: "42", Type: ValueType_VALUE_TYPE_INTEGER},
+					{Key: "score", Value: "1.5", Type: ValueType_VALUE_TYPE_FLOAT},
+					{Key: "name", Value: "foo", Type: ValueType_VALUE_TYPE_STRING},
+					{Key: "created_at", Value: "2017-03-01 12:30:45", Type: ValueType_VALUE_TYPE_TIME},
+				},
+			},
+		},
+	}
+
+	columns := rows.Columns()
+	if len(columns) != 4 || columns[0] != "id" || columns[3] != "created_at" {
+		t.Fatalf("unexpected columns: %v", columns)
+	}
+
+	dest := make([]driver.Value, len(columns))
+	if err := rows.Next(dest); err != nil {
+		t.Fatalf("rows.Next() error: %v", err)
+	}
+
+	if v, ok := dest[0].(int64); !ok || v != 42 {
+		t.Errorf("id: got %#v, want int64(42)", dest[0])
+	}
+	if v, ok := dest[1].(float64); !ok || v != 1.5 {
+		t.Errorf("score: got %#v, want float64(1.5)", dest[1])
+	}
+	if v, ok := dest[2].(string); !ok || v != "foo" {
+		t.Errorf("name: got %#v, want \"foo\"", dest[2])
+	}
+	want := time.Date(2017, 3, 1, 12, 30, 45, 0, time.UTC)
+	if v, ok := dest[3].(time.Time); !ok || !v.Equal(want) {
+		t.Errorf("created_at: got %#v, want %v", dest[3], want)
+	}
+
+	if err := rows.Next(dest); err != io.EOF {
+		t.Errorf("rows.Next() after last row: got %v, want io.EOF", err)
+	}
+}
+
+func TestRowsColumnTypes(t *testing.T) {
+	rows := &Rows{
+		records: []*StoreRecord{
+			{
+				Units: []*KVPair{
+					{Key: "id", Value: "1", Type: ValueType_VALUE_TYPE_INTEGER},
+					{Key: "raw", Value: "x"},
+				},
+			},
+		},
+	}
+
+	if name := rows.ColumnTypeDatabaseTypeName(0); name != "BIGINT" {
+		t.Errorf("ColumnTypeDatabaseTypeName(0): got %q, want BIGINT", name)
+	}
+	if typ := rows.ColumnTypeScanType(0); typ != scanTypeInt64 {
+		t.Errorf("ColumnTypeScanType(0): got %v, want int64", typ)
+	}
+	if typ := rows.ColumnTypeScanType(1); typ != scanTypeString {
+		t.Errorf("ColumnTypeScanType(1): got %v, want string", typ)
+	}
+}
+
+func TestRowsNextNull(t *testing.T) {
+	records := []*StoreRecord{
+		{
+			Units: []*KVPair{
+				{Key: "id", Value: "", Type: ValueType_VALUE_TYPE_INTEGER},
+				{Key: "name", Value: "", Type: ValueType_VALUE_TYPE_STRING},
+				{Key: "note", Value: ""},
+				{Key: "updated_at", Value: "", Type: ValueType_VALUE_TYPE_TIME},
+			},
+		},
+		{
+			Units: []*KVPair{
+				{Key: "id", Value: "7", Type: ValueType_VALUE_TYPE_INTEGER},
+				{Key: "name", Value: "bar", Type: ValueType_VALUE_TYPE_STRING},
+				{Key: "note", Value: "baz"},
+				{Key: "updated_at", Value: "2017-03-01", Type: ValueType_VALUE_TYPE_TIME},
+			},
+		},
+	}
+
+	tests := []struct {
+		cfg  *Config
+		want []driver.Value
+	}{
+		{&Config{}, []driver.Value{nil, "", "", nil}},
+		{&Config{EmptyAsNull: true}, []driver.Value{nil, "", nil, nil}},
+	}
+
+	for _, test := range tests {
+		rows := newRows(test.cfg, records)
+		dest := make([]driver.Value, len(rows.Columns()))
+		if err := rows.Next(dest); err != nil {
+			t.Fatalf("rows.Next() error: %v", err)
+		}
+
+		for i, want := range test.want {
+			if dest[i] != want {
+				t.Errorf("emptyAsNull=%v, column %v: got %#v, want %#v",
+					test.cfg.EmptyAsNull, rows.Columns()[i], dest[i], want)
+			}
+		}
+
+		if typ := rows.ColumnTypeScanType(3); typ != scanTypeTime {
+			t.Errorf("ColumnTypeScanType(3): got %v, want time.Time", typ)
+		}
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"strings"
 
 	"github.com/stn81/bigid"
-	"github.com/stn81/log"
 	"github.com/stn81/sqlparser"
 	"github.com/stn81/kate/utils"
 )
@@ -17,6 +16,7 @@ type selectExecutor struct {
 	ctx       context.Context
 	dbc       *Conn
 	ast       *sqlparser.Select
+	vars      bindVars
 	table     string
 	columns   string
 	filters   string
@@ -56,7 +56,7 @@ func (exr *selectExecutor) Run() (rows driver.Rows, err error) {
 	}
 
 	if resp, err = exr.dbc.call(exr.ctx, req); err != nil {
-		log.Error(exr.ctx, "db.select", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
+		exr.dbc.logger().Error(exr.ctx, "db.select", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
 		return nil, driver.ErrBadConn
 	}
 
@@ -65,25 +65,23 @@ func (exr *selectExecutor) Run() (rows driver.Rows, err error) {
 		if sqlInfo == nil {
 
 			sqlInfo = &MysqlInfo{
-				Sql:    astValue(exr.ast),
+				Sql:    exr.vars.value(exr.ast),
 				Vsid:   utils.GetInt32(bigid.GetVSId(exr.BigId)),
 				Dbname: exr.DBName,
 			}
 		}
 		err = NewDBError(resp.GetError(), resp.GetErrMsg(), sqlInfo)
-		log.Error(exr.ctx, "db.select", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
+		exr.dbc.logger().Error(exr.ctx, "db.select", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
 		return
 	}
 
 	selectResp := resp.GetSelectResp()
 	if selectResp == nil {
-		log.Error(exr.ctx, "db.select", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", "no select response")
+		exr.dbc.logger().Error(exr.ctx, "db.select", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", "no select response")
 		return nil, driver.ErrBadConn
 	}
 
-	rows = &Rows{
-		records: selectResp.GetRecords(),
-	}
+	rows = newRows(exr.dbc.Config, selectResp.GetRecords())
 	return
 }
 
@@ -94,43 +92,43 @@ func (exr *selectExecutor) parse() error {
 	)
 	if exr.ast.Lock != "" {
 		if exr.ast.Lock != " for update" {
-			panic(ErrSqlInvalid(fmt.Sprintf("lock type `%v` not supported", exr.ast.Lock)))
+			return newSQLValidationError("lock", fmt.Sprintf("lock type `%v` not supported", exr.ast.Lock))
 		}
 		exr.forUpdate = 1
 	}
 
 	if len(exr.ast.GroupBy) > 0 {
 		if !exr.Offline {
-			panic(ErrSqlInvalid("`group by` is only supported for offline db"))
+			return newSQLValidationError("group by", "`group by` is only supported for offline db")
 		} else {
-			groupBy = astValue(exr.ast.GroupBy)
+			groupBy = exr.vars.value(exr.ast.GroupBy)
 		}
 	}
 
 	if exr.ast.Having != nil {
 		if !exr.Offline {
-			panic(ErrSqlInvalid("`having` is only supported for offline db"))
+			return newSQLValidationError("having", "`having` is only supported for offline db")
 		} else {
-			having = astValue(exr.ast.Having)
+			having = exr.vars.value(exr.ast.Having)
 		}
 	}
 
 	if exr.ast.Where == nil {
-		panic(ErrSqlInvalid("missing `where`"))
+		return newSQLValidationError("where", "missing `where`")
 	}
 
 	if exr.ast.Where.Type != "where" {
-		panic(ErrSqlInvalid(fmt.Sprintf("filters `%v` not supported", exr.ast.Where.Type)))
+		return newSQLValidationError("where", fmt.Sprintf("filters `%v` not supported", exr.ast.Where.Type))
 	}
 
 	if exr.DBName == "" {
 		exr.DBName = exr.dbc.DBName
 	}
 
-	exr.columns = fmt.Sprint(exr.ast.Distinct, astValue(exr.ast.SelectExprs))
-	exr.table = astValue(exr.ast.From)
-	exr.filters = fmt.Sprintf("%s%s%s", astValue(exr.ast.Where.Expr), groupBy, having)
-	exr.orderBy = strings.TrimPrefix(astValue(exr.ast.OrderBy), " order by ")
-	exr.limit = strings.TrimPrefix(astValue(exr.ast.Limit), " limit ")
+	exr.columns = fmt.Sprint(exr.ast.Distinct, exr.vars.value(exr.ast.SelectExprs))
+	exr.table = exr.vars.value(exr.ast.From)
+	exr.filters = fmt.Sprintf("%s%s%s", exr.vars.value(exr.ast.Where.Expr), groupBy, having)
+	exr.orderBy = strings.TrimPrefix(exr.vars.value(exr.ast.OrderBy), " order by ")
+	exr.limit = strings.TrimPrefix(exr.vars.value(exr.ast.Limit), " limit ")
 	return nil
 }
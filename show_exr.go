@@ -0,0 +1,83 @@
+package cdbpool
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/stn81/bigid"
+	"github.com/stn81/kate/utils"
+)
+
+type showExecutor struct {
+	*RouteInfo
+	ctx     context.Context
+	dbc     *Conn
+	content string
+}
+
+func (exr *showExecutor) Run() (rows driver.Rows, err error) {
+	if exr.DBName == "" {
+		exr.DBName = exr.dbc.DBName
+	}
+
+	var (
+		req   *CdbPoolRequest
+		resp  *CdbPoolResponse
+		logId = fmt.Sprintf("%s.show", exr.DBName)
+	)
+
+	req = &CdbPoolRequest{
+		Logid:               logId,
+		Command:             "ori_show",
+		Bigid:               exr.BigId,
+		RequestOfflineMysql: exr.Offline,
+		NeedSqlInfo:         true,
+		Req: &CdbPoolRequest_OriShowReq{
+			&OriShowRequest{
+				Dbname:  exr.DBName,
+				Content: exr.content,
+			},
+		},
+	}
+
+	if resp, err = exr.dbc.call(exr.ctx, req); err != nil {
+		exr.dbc.logger().Error(exr.ctx, "db.show", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
+		return nil, driver.ErrBadConn
+	}
+
+	if ResultCode(resp.GetError()) != ResultCode_RC_SUCCESS {
+		sqlInfo := resp.GetSqlInfo()
+		if sqlInfo == nil {
+			sqlInfo = &MysqlInfo{
+				Sql:    exr.content,
+				Vsid:   utils.GetInt32(bigid.GetVSId(exr.BigId)),
+				Dbname: exr.DBName,
+			}
+		}
+		err = NewDBError(resp.GetError(), resp.GetErrMsg(), sqlInfo)
+		exr.dbc.logger().Error(exr.ctx, "db.show", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
+		return
+	}
+
+	showResp := resp.GetOriShowResp()
+	if showResp == nil {
+		exr.dbc.logger().Error(exr.ctx, "db.show", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", "no show response")
+		return nil, driver.ErrBadConn
+	}
+
+	rows = newRows(exr.dbc.Config, showResp.GetRecords())
+	return
+}
+
+// isShowStatement reports whether q is a SHOW statement, which the sql
+// parser does not handle and is forwarded to the server verbatim.
+func isShowStatement(q string) bool {
+	q = strings.TrimLeftFunc(q, unicode.IsSpace)
+	if len(q) < 5 || !strings.EqualFold(q[:4], "show") {
+		return false
+	}
+	return unicode.IsSpace(rune(q[4]))
+}
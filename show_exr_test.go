@@ -0,0 +1,23 @@
+package cdbpool
+
+import "testing"
+
+func TestIsShowStatement(t *testing.T) {
+	tests := []struct {
+		q    string
+		want bool
+	}{
+		{"show tables", true},
+		{"  SHOW CREATE TABLE x", true},
+		{"show\tindex from x", true},
+		{"showtables", false},
+		{"select * from shows where 1=1", false},
+		{"show", false},
+	}
+
+	for _, test := range tests {
+		if got := isShowStatement(test.q); got != test.want {
+			t.Errorf("isShowStatement(%q): got %v, want %v", test.q, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package cdbpool
+
+import (
+	"context"
+	"database/sql/driver"
+)
+
+// ServerStats holds the statistics reported by a cdbpool server.
+type ServerStats struct {
+	Addr     string                 // Server address
+	Stats    map[string]interface{} // Stats decoded by their ValueType
+	StatsStr string                 // Raw mysql stats, if reported
+}
+
+type statsExecutor struct {
+	ctx context.Context
+	dbc *Conn
+}
+
+func (exr *statsExecutor) Run() (stats *ServerStats, err error) {
+	if exr.dbc.client == nil || !exr.dbc.client.IsConnected() {
+		return nil, driver.ErrBadConn
+	}
+
+	var (
+		req  *CdbPoolRequest
+		resp *CdbPoolResponse
+	)
+
+	req = &CdbPoolRequest{
+		Logid:   "server.stats",
+		Command: "stats",
+		Req: &CdbPoolRequest_StatsReq{
+			&ServerStatsRequest{},
+		},
+	}
+
+	if resp, err = exr.dbc.call(exr.ctx, req); err != nil {
+		exr.dbc.logger().Error(exr.ctx, "db.stats", "logid", req.Logid, "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
+		return nil, driver.ErrBadConn
+	}
+
+	if ResultCode(resp.GetError()) != ResultCode_RC_SUCCESS {
+		err = NewDBError(resp.GetError(), resp.GetErrMsg(), resp.GetSqlInfo())
+		exr.dbc.logger().Error(exr.ctx, "db.stats", "logid", req.Logid, "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
+		return
+	}
+
+	statsResp, mysqlStatsResp := resp.GetStatsResp(), resp.GetMysqlStatsResp()
+	if statsResp == nil && mysqlStatsResp == nil {
+		exr.dbc.logger().Error(exr.ctx, "db.stats", "logid", req.Logid, "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", "no stats response")
+		return nil, driver.ErrBadConn
+	}
+
+	stats = &ServerStats{
+		Addr:     exr.dbc.Addr,
+		Stats:    make(map[string]interface{}, len(statsResp.GetStats())),
+		StatsStr: mysqlStatsResp.GetStatsStr(),
+	}
+	for _, kv := range statsResp.GetStats() {
+		stats.Stats[kv.Key] = decodeValue(exr.dbc.Config, kv)
+	}
+	return
+}
@@ -22,17 +22,84 @@ type Stmt struct {
 	ctx        context.Context
 	dbc        *Conn
 	query      string
-	paramCount int
+	params     []placeholder
+	paramCount int        // number of ? placeholders, -1 with named parameters
+	hint       *RouteInfo // route of the query hint, if any
 }
 
-func newStmt(ctx context.Context, dbc *Conn, query string) *Stmt {
+func newStmt(ctx context.Context, dbc *Conn, query string) (*Stmt, error) {
+	hint, query, err := parseRouteHint(query)
+	if err != nil {
+		return nil, err
+	}
+
 	stmt := &Stmt{
-		ctx:        ctx,
-		dbc:        dbc,
-		query:      query,
-		paramCount: strings.Count(query, "?"),
+		ctx:    ctx,
+		dbc:    dbc,
+		query:  query,
+		params: scanPlaceholders(query),
+		hint:   hint,
+	}
+
+	for _, param := range stmt.params {
+		if param.name != "" {
+			// The same named argument can be used several times.
+			stmt.paramCount = -1
+			break
+		}
+		stmt.paramCount++
 	}
-	return stmt
+	return stmt, nil
+}
+
+// route returns the route of the statement, which is by precedence the
+// route of ctx, of the running transaction, or of the query hint.
+func (stmt *Stmt) route(ctx context.Context) (context.Context, *RouteInfo) {
+	if route := GetRoute(ctx); route != nil {
+		return ctx, route
+	}
+
+	if stmt.dbc.ctx != nil {
+		if route := GetRoute(stmt.dbc.ctx); route != nil {
+			return stmt.dbc.ctx, route
+		}
+	}
+
+	if stmt.hint != nil {
+		route := *stmt.hint
+		return ctx, &route
+	}
+	return ctx, nil
+}
+
+// resolveRoute returns route with the bigId derived from statement and its
+// vars by the RouteResolver of the connection, if any. The derived bigId takes
+// precedence over the route of ctx and hints, but not over the route of a
+// running transaction.
+func (stmt *Stmt) resolveRoute(route *RouteInfo, statement sqlparser.Statement, vars bindVars) *RouteInfo {
+	if stmt.dbc.RouteResolver == nil || stmt.dbc.ctx != nil {
+		return route
+	}
+
+	var (
+		bigId uint64
+		ok    bool
+	)
+	if resolver, isVars := stmt.dbc.RouteResolver.(varsRouteResolver); isVars {
+		bigId, ok = resolver.resolveBigIdVars(statement, vars)
+	} else {
+		bigId, ok = stmt.dbc.RouteResolver.ResolveBigId(statement)
+	}
+	if !ok {
+		return route
+	}
+
+	resolved := &RouteInfo{BigId: bigId}
+	if route != nil {
+		resolved.DBName = route.DBName
+		resolved.Offline = route.Offline
+	}
+	return resolved
 }
 
 func (stmt *Stmt) Close() error {
@@ -57,27 +124,22 @@ func (stmt *Stmt) Query(args []driver.Value) (driver.Rows, error) {
 func (stmt *Stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (result driver.Result, err error) {
 	var (
 		route     *RouteInfo
-		q         string
 		statement sqlparser.Statement
+		vars      bindVars
 	)
 
-	if route = GetRoute(ctx); route == nil {
-		if stmt.dbc.ctx != nil {
-			ctx = stmt.dbc.ctx
-			route = GetRoute(ctx)
-		}
-	}
+	defer func() {
+		err = stmt.dbc.checkSQLError(err)
+	}()
 
-	if route == nil {
-		panic(ErrMissingRouteInfo)
-	}
+	ctx, route = stmt.route(ctx)
 
-	if q, err = stmt.interpolateParams(args); err != nil {
+	if statement, vars, err = stmt.parse(args); err != nil {
 		return
 	}
 
-	if statement, err = sqlparser.Parse(q); err != nil {
-		return
+	if route = stmt.resolveRoute(route, statement, vars); route == nil {
+		return nil, ErrMissingRouteInfo
 	}
 
 	switch ast := statement.(type) {
@@ -87,6 +149,7 @@ func (stmt *Stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (re
 			ctx:       ctx,
 			dbc:       stmt.dbc,
 			ast:       ast,
+			vars:      vars,
 		}
 		return exr.Run()
 	case *sqlparser.Insert:
@@ -95,6 +158,7 @@ func (stmt *Stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (re
 			ctx:       ctx,
 			dbc:       stmt.dbc,
 			ast:       ast,
+			vars:      vars,
 		}
 		return exr.Run()
 	case *sqlparser.Delete:
@@ -103,43 +167,71 @@ func (stmt *Stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (re
 			ctx:       ctx,
 			dbc:       stmt.dbc,
 			ast:       ast,
+			vars:      vars,
 		}
 		return exr.Run()
 	default:
-		panic(ErrSqlNotSupport)
+		return nil, &SQLValidationError{
+			Clause: "statement",
+			Detail: "only select, insert, update and delete are supported",
+			Err:    ErrSqlNotSupport,
+		}
+	}
+}
+
+func (stmt *Stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if hr := getHedgedRead(ctx); hr != nil {
+		return hr.query(ctx, stmt, args)
 	}
+	return stmt.queryContext(ctx, args)
 }
 
-func (stmt *Stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (rows driver.Rows, err error) {
+func (stmt *Stmt) queryContext(ctx context.Context, args []driver.NamedValue) (rows driver.Rows, err error) {
 	var (
 		route     *RouteInfo
 		q         string
 		statement sqlparser.Statement
+		vars      bindVars
 		ast       *sqlparser.Select
 		ok        bool
 	)
 
-	if route = GetRoute(ctx); route == nil {
-		if stmt.dbc.ctx != nil {
-			ctx = stmt.dbc.ctx
-			route = GetRoute(ctx)
-		}
-	}
+	defer func() {
+		err = stmt.dbc.checkSQLError(err)
+	}()
+
+	ctx, route = stmt.route(ctx)
 
-	if route == nil {
-		panic(ErrMissingRouteInfo)
+	if isShowStatement(stmt.query) {
+		if route == nil {
+			return nil, ErrMissingRouteInfo
+		}
+		if q, err = stmt.interpolateParams(args); err != nil {
+			return
+		}
+		exr := &showExecutor{
+			RouteInfo: route,
+			ctx:       ctx,
+			dbc:       stmt.dbc,
+			content:   strings.TrimSpace(q),
+		}
+		return exr.Run()
 	}
 
-	if q, err = stmt.interpolateParams(args); err != nil {
+	if statement, vars, err = stmt.parse(args); err != nil {
 		return
 	}
 
-	if statement, err = sqlparser.Parse(q); err != nil {
-		return
+	if route = stmt.resolveRoute(route, statement, vars); route == nil {
+		return nil, ErrMissingRouteInfo
 	}
 
 	if ast, ok = statement.(*sqlparser.Select); !ok {
-		panic(ErrSqlNotSupport)
+		return nil, &SQLValidationError{
+			Clause: "statement",
+			Detail: "only select and show are supported for queries",
+			Err:    ErrSqlNotSupport,
+		}
 	}
 
 	exr := &selectExecutor{
@@ -147,62 +239,136 @@ func (stmt *Stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (r
 		ctx:       ctx,
 		dbc:       stmt.dbc,
 		ast:       ast,
+		vars:      vars,
 	}
 
 	return exr.Run()
 }
 
+// parse returns the statement of the query with args. The statement of a
+// cached template is shared, its placeholders are filled in by vars.
+func (stmt *Stmt) parse(args []driver.NamedValue) (statement sqlparser.Statement, vars bindVars, err error) {
+	if vars, err = stmt.bindArgs(args); err != nil {
+		return
+	}
+
+	// Resolvers not knowing templates need the literal statement.
+	if _, ok := stmt.dbc.RouteResolver.(varsRouteResolver); ok || stmt.dbc.RouteResolver == nil {
+		if tpl := gStmtCache.get(stmt.query, stmt.params); tpl != nil && tpl.statement != nil {
+			return tpl.statement, vars, nil
+		}
+	}
+
+	statement, err = sqlparser.Parse(stmt.splice(vars))
+	return statement, nil, err
+}
+
 func (stmt *Stmt) interpolateParams(args []driver.NamedValue) (string, error) {
-	// Number of ? should be same to len(args)
-	if stmt.paramCount != len(args) {
-		return "", driver.ErrSkip
+	vars, err := stmt.bindArgs(args)
+	if err != nil {
+		return "", err
+	}
+	return stmt.splice(vars), nil
+}
+
+// splice returns the query with the placeholders replaced by vars.
+func (stmt *Stmt) splice(vars bindVars) string {
+	if len(stmt.params) == 0 {
+		return stmt.query
 	}
 
 	var (
-		buf    bytes.Buffer // TODO: cache buffers
-		argPos = 0
+		buf  bytes.Buffer // TODO: cache buffers
+		last = 0
 	)
 
-	for i := 0; i < len(stmt.query); i++ {
-		q := strings.IndexByte(stmt.query[i:], '?')
-		if q == -1 {
-			buf.WriteString(stmt.query[i:])
-			break
-		}
-		buf.WriteString(stmt.query[i : i+q])
-		i += q
+	for i, param := range stmt.params {
+		buf.WriteString(stmt.query[last:param.begin])
+		buf.WriteString(vars[i].sql)
+		last = param.end
+	}
+	buf.WriteString(stmt.query[last:])
+	return buf.String()
+}
 
-		arg := args[argPos].Value
-		argPos++
+// bindArgs returns the SQL literals of args for the placeholders of the
+// query, in order. Named placeholders are only bound if args has named
+// arguments, and are otherwise kept as is.
+func (stmt *Stmt) bindArgs(args []driver.NamedValue) (bindVars, error) {
+	var (
+		ordinal []driver.NamedValue
+		named   map[string]driver.NamedValue
+	)
 
-		if arg == nil {
-			buf.WriteString("NULL")
+	for _, arg := range args {
+		if arg.Name == "" {
+			ordinal = append(ordinal, arg)
 			continue
 		}
+		if named == nil {
+			named = make(map[string]driver.NamedValue)
+		}
+		named[arg.Name] = arg
+	}
+
+	var (
+		buf    bytes.Buffer
+		vars   = make(bindVars, len(stmt.params))
+		argPos = 0
+		used   = make(map[string]bool, len(named))
+		size   = len(stmt.query)
+		loc    = stmt.dbc.location()
+	)
 
-		switch v := arg.(type) {
-		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
-			buf.WriteString(fmt.Sprint(v))
-		case bool:
-			if v {
-				buf.WriteByte('1')
-			} else {
-				buf.WriteByte('0')
+	for i, param := range stmt.params {
+		var arg driver.NamedValue
+
+		if param.name == "" {
+			if argPos >= len(ordinal) {
+				return nil, stmt.argCountError(len(ordinal))
+			}
+			arg = ordinal[argPos]
+			argPos++
+		} else if named == nil {
+			// e.g. the user variables of "select @n := @n + 1"
+			vars[i] = bindVar{sql: stmt.query[param.begin:param.end]}
+			continue
+		} else {
+			var ok bool
+			if arg, ok = named[param.name]; !ok {
+				return nil, newSQLValidationError("args", "missing argument of named parameter "+stmt.query[param.begin:param.end])
 			}
-		case string:
-			buf.WriteByte('\'')
-			escapeStringVal(&buf, v)
-			buf.WriteByte('\'')
-		default:
-			return "", driver.ErrSkip
+			used[param.name] = true
+		}
+
+		buf.Reset()
+		if err := writeArg(&buf, arg.Value, loc); err != nil {
+			return nil, &ArgError{Ordinal: arg.Ordinal, Value: arg.Value, Err: err}
+		}
+		vars[i] = bindVar{sql: buf.String(), value: arg.Value}
+
+		if size += len(vars[i].sql) - (param.end - param.begin); stmt.dbc.MaxAllowedPacket > 0 && size > stmt.dbc.MaxAllowedPacket {
+			return nil, ErrSqlTooLarge
 		}
+	}
 
-		if stmt.dbc.MaxAllowedPacket > 0 && buf.Len() > stmt.dbc.MaxAllowedPacket {
-			return "", ErrSqlTooLarge
+	if argPos != len(ordinal) {
+		return nil, stmt.argCountError(len(ordinal))
+	}
+	for name := range named {
+		if !used[name] {
+			return nil, newSQLValidationError("args", "unused named argument "+name)
 		}
 	}
-	if argPos != len(args) {
-		return "", driver.ErrSkip
+	return vars, nil
+}
+
+func (stmt *Stmt) argCountError(n int) error {
+	var count int
+	for _, param := range stmt.params {
+		if param.name == "" {
+			count++
+		}
 	}
-	return buf.String(), nil
+	return newSQLValidationError("args", fmt.Sprintf("query has %d placeholders, got %d arguments", count, n))
 }
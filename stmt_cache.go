@@ -0,0 +1,236 @@
+package cdbpool
+
+import (
+	"container/list"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/stn81/sqlparser"
+)
+
+const (
+	defaultStmtCacheSize = 1024
+
+	argMarkerPrefix = ":__cdbpool_arg_"
+	argMarkerSuffix = "__"
+)
+
+var gStmtCache = newStmtCache(defaultStmtCacheSize)
+
+// StmtCacheStats are the counters of the parsed statement cache shared by
+// all the connections.
+type StmtCacheStats struct {
+	Hits      uint64 // Lookups finding the parsed template
+	Misses    uint64 // Lookups parsing the template
+	Evictions uint64 // Templates evicted by newer ones
+	Size      int    // Number of cached templates
+	Capacity  int    // Max number of cached templates, 0 if disabled
+}
+
+// GetStmtCacheStats returns the counters of the parsed statement cache.
+func GetStmtCacheStats() StmtCacheStats {
+	return gStmtCache.stats()
+}
+
+// SetStmtCacheSize sets the max number of parsed query templates cached,
+// 1024 by default. A size <= 0 disables the cache.
+func SetStmtCacheSize(n int) {
+	gStmtCache.resize(n)
+}
+
+// template is a query parsed with its placeholders replaced by markers,
+// which are filled in by the bindVars of every execution.
+type template struct {
+	query     string
+	statement sqlparser.Statement // nil if the template does not parse
+}
+
+type stmtCache struct {
+	mu        sync.Mutex
+	capacity  int
+	lru       *list.List // of *template, most recently used first
+	items     map[string]*list.Element
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+func newStmtCache(capacity int) *stmtCache {
+	return &stmtCache{
+		capacity: capacity,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// get returns the template of query, parsing it on a miss. It returns nil
+// if the cache is disabled. The statement of the template is shared and
+// must not be modified.
+func (c *stmtCache) get(query string, params []placeholder) *template {
+	c.mu.Lock()
+	if c.capacity <= 0 {
+		c.mu.Unlock()
+		return nil
+	}
+	if elem, ok := c.items[query]; ok {
+		c.hits++
+		c.lru.MoveToFront(elem)
+		c.mu.Unlock()
+		return elem.Value.(*template)
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	tpl := &template{query: query}
+	if statement, err := sqlparser.Parse(markPlaceholders(query, params)); err == nil {
+		tpl.statement = statement
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[query]; ok {
+		// parsed concurrently
+		return elem.Value.(*template)
+	}
+	c.items[query] = c.lru.PushFront(tpl)
+	c.evict()
+	return tpl
+}
+
+func (c *stmtCache) resize(capacity int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.capacity = capacity
+	c.evict()
+}
+
+// evict removes the least recently used templates above the capacity.
+func (c *stmtCache) evict() {
+	for c.lru.Len() > 0 && c.lru.Len() > c.capacity {
+		elem := c.lru.Back()
+		c.lru.Remove(elem)
+		delete(c.items, elem.Value.(*template).query)
+		c.evictions++
+	}
+}
+
+func (c *stmtCache) stats() StmtCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	capacity := c.capacity
+	if capacity < 0 {
+		capacity = 0
+	}
+	return StmtCacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Size:      c.lru.Len(),
+		Capacity:  capacity,
+	}
+}
+
+// markPlaceholders returns query with the i-th placeholder replaced by the
+// bind variable :__cdbpool_arg_i__.
+func markPlaceholders(query string, params []placeholder) string {
+	if len(params) == 0 {
+		return query
+	}
+
+	var (
+		buf  strings.Builder
+		last = 0
+	)
+	for i, param := range params {
+		buf.WriteString(query[last:param.begin])
+		buf.WriteString(argMarkerPrefix)
+		buf.WriteString(strconv.Itoa(i))
+		buf.WriteString(argMarkerSuffix)
+		last = param.end
+	}
+	buf.WriteString(query[last:])
+	return buf.String()
+}
+
+// bindVar is a placeholder of a statement bound to its argument.
+type bindVar struct {
+	sql   string      // SQL literal of the argument
+	value interface{} // argument, nil if the placeholder is kept as is
+}
+
+// bindVars are the placeholders of a statement, in order.
+type bindVars []bindVar
+
+// value formats node with the markers of a template replaced by vars.
+func (vars bindVars) value(node sqlparser.SQLNode) string {
+	return vars.bind(astValue(node))
+}
+
+// bind replaces the markers of s by vars.
+func (vars bindVars) bind(s string) string {
+	if len(vars) == 0 || !strings.Contains(s, argMarkerPrefix) {
+		return s
+	}
+
+	var buf strings.Builder
+	for {
+		begin := strings.Index(s, argMarkerPrefix)
+		if begin < 0 {
+			break
+		}
+		i, end, ok := vars.marker(s[begin:])
+		if !ok {
+			buf.WriteString(s[:begin+len(argMarkerPrefix)])
+			s = s[begin+len(argMarkerPrefix):]
+			continue
+		}
+		buf.WriteString(s[:begin])
+		buf.WriteString(vars[i].sql)
+		s = s[begin+end:]
+	}
+	buf.WriteString(s)
+	return buf.String()
+}
+
+// marker parses the marker at the beginning of s, returning the index of
+// its var and the length of the marker.
+func (vars bindVars) marker(s string) (index, length int, ok bool) {
+	if !strings.HasPrefix(s, argMarkerPrefix) {
+		return 0, 0, false
+	}
+
+	digits := s[len(argMarkerPrefix):]
+	n := 0
+	for n < len(digits) && '0' <= digits[n] && digits[n] <= '9' {
+		n++
+	}
+	if n == 0 || !strings.HasPrefix(digits[n:], argMarkerSuffix) {
+		return 0, 0, false
+	}
+
+	index, err := strconv.Atoi(digits[:n])
+	if err != nil || index >= len(vars) {
+		return 0, 0, false
+	}
+	return index, len(argMarkerPrefix) + n + len(argMarkerSuffix), true
+}
+
+// literal returns the value of the literal val, which is the argument of
+// the bind variable of a template.
+func (vars bindVars) literal(val sqlparser.ValExpr) (interface{}, bool) {
+	switch v := val.(type) {
+	case sqlparser.NumVal:
+		return string(v), true
+	case sqlparser.StrVal:
+		return string(v), true
+	case sqlparser.ValArg:
+		if i, n, ok := vars.marker(string(v)); ok && n == len(v) && vars[i].value != nil {
+			return vars[i].value, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,141 @@
+package cdbpool
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/stn81/bigid"
+	"github.com/stn81/sqlparser"
+)
+
+func TestStmtCache(t *testing.T) {
+	c := newStmtCache(2)
+
+	for _, query := range []string{
+		"delete from a where id = 1",
+		"delete from b where id = 1",
+		"delete from a where id = 1",
+		"delete from c where id = 1",
+		"delete from a where id = 1",
+	} {
+		if tpl := c.get(query, nil); tpl == nil || tpl.statement == nil {
+			t.Fatalf("get(%q): got %+v", query, tpl)
+		}
+	}
+
+	want := StmtCacheStats{Hits: 2, Misses: 3, Evictions: 1, Size: 2, Capacity: 2}
+	if got := c.stats(); got != want {
+		t.Errorf("stats: got %+v, want %+v", got, want)
+	}
+
+	if tpl := c.get("not sql", nil); tpl == nil || tpl.statement != nil {
+		t.Errorf("invalid template: got %+v, want no statement", tpl)
+	}
+
+	c.resize(0)
+	if tpl := c.get("delete from a where id = 1", nil); tpl != nil {
+		t.Errorf("disabled cache: got %+v, want nil", tpl)
+	}
+	if got := c.stats(); got.Size != 0 || got.Capacity != 0 {
+		t.Errorf("disabled cache stats: got %+v", got)
+	}
+}
+
+func TestBindVars(t *testing.T) {
+	vars := bindVars{{sql: "1", value: int64(1)}, {sql: "'x'", value: "x"}}
+
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"a = :__cdbpool_arg_0__ and b = :__cdbpool_arg_1__", "a = 1 and b = 'x'"},
+		{"a = :__cdbpool_arg_1__:__cdbpool_arg_0__", "a = 'x'1"},
+		{"a = :__cdbpool_arg_2__ and b = :__cdbpool_arg_x__", "a = :__cdbpool_arg_2__ and b = :__cdbpool_arg_x__"},
+		{"a = :id", "a = :id"},
+	}
+
+	for _, test := range tests {
+		if got := vars.bind(test.s); got != test.want {
+			t.Errorf("bind(%q): got %q, want %q", test.s, got, test.want)
+		}
+	}
+}
+
+func TestStmtParseCached(t *testing.T) {
+	var (
+		query  = "delete from cached where name = 'why?' and id = ?"
+		conn   = &Conn{Config: &Config{RouteResolver: NewShardKeyResolver(nil)}}
+		before = GetStmtCacheStats()
+		first  sqlparser.Statement
+	)
+
+	for i := 1; i <= 3; i++ {
+		stmt, err := newStmt(context.Background(), conn, query)
+		if err != nil {
+			t.Fatalf("newStmt: err=%v", err)
+		}
+
+		statement, vars, err := stmt.parse([]driver.NamedValue{{Ordinal: 1, Value: int64(bigid.New(uint64(i)))}})
+		if err != nil {
+			t.Fatalf("parse: err=%v", err)
+		}
+		if first == nil {
+			first = statement
+		} else if statement != first {
+			t.Errorf("parse #%d: got a new statement, want the cached one", i)
+		}
+
+		route := stmt.resolveRoute(nil, statement, vars)
+		if route == nil || route.BigId != bigid.New(uint64(i)) {
+			t.Errorf("parse #%d: got route %+v, want bigid %v", i, route, bigid.New(uint64(i)))
+		}
+
+		want := stmt.splice(vars)
+		if got := "delete from cached where " + vars.value(statement.(*sqlparser.Delete).Where.Expr); got != want {
+			t.Errorf("parse #%d: got %q, want %q", i, got, want)
+		}
+	}
+
+	after := GetStmtCacheStats()
+	if after.Misses-before.Misses != 1 || after.Hits-before.Hits != 2 {
+		t.Errorf("stats: got %+v after %+v, want 1 miss and 2 hits", after, before)
+	}
+}
+
+func TestStmtResolveRouteArgs(t *testing.T) {
+	var (
+		conn  = &Conn{Config: &Config{RouteResolver: NewShardKeyResolver(nil)}}
+		tests = []struct {
+			arg   interface{}
+			bigId uint64
+			ok    bool
+		}{
+			{int64(bigid.New(3)), bigid.New(3), true},
+			{bigid.New(3), bigid.New(3), true},
+			{"12", 12, true},
+			{[]byte("12"), 12, true},
+			{"1'2", 0, false},
+			{"X'3132'", 0, false},
+			{int64(-1), 0, false},
+			{1.5, 0, false},
+		}
+	)
+
+	for _, test := range tests {
+		stmt, err := newStmt(context.Background(), conn, "delete from cached where id = ?")
+		if err != nil {
+			t.Fatalf("newStmt: err=%v", err)
+		}
+
+		statement, vars, err := stmt.parse([]driver.NamedValue{{Ordinal: 1, Value: test.arg}})
+		if err != nil {
+			t.Fatalf("parse %#v: err=%v", test.arg, err)
+		}
+
+		route := stmt.resolveRoute(nil, statement, vars)
+		if ok := route != nil; ok != test.ok || ok && route.BigId != test.bigId {
+			t.Errorf("resolve %#v: got route %+v, want bigid %v, %v", test.arg, route, test.bigId, test.ok)
+		}
+	}
+}
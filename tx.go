@@ -20,18 +20,20 @@ func newTx(ctx context.Context, dbc *Conn, route *RouteInfo) *Tx {
 	return tx
 }
 
-func (tx *Tx) Commit() error {
+func (tx *Tx) Commit() (err error) {
 	defer func() {
 		tx.dbc.ctx = nil
+		tx.dbc.unpin(err == nil)
 	}()
 
 	exr := &commitExecutor{tx}
 	return exr.Run()
 }
 
-func (tx *Tx) Rollback() error {
+func (tx *Tx) Rollback() (err error) {
 	defer func() {
 		tx.dbc.ctx = nil
+		tx.dbc.unpin(err == nil)
 	}()
 
 	exr := &rollbackExecutor{tx}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 
 	"github.com/stn81/bigid"
-	"github.com/stn81/log"
 	"github.com/stn81/sqlparser"
 	"github.com/stn81/kate/utils"
 )
@@ -16,6 +15,7 @@ type updateExecutor struct {
 	ctx     context.Context
 	dbc     *Conn
 	ast     *sqlparser.Update
+	vars    bindVars
 	table   string
 	values  string
 	filters string
@@ -48,7 +48,7 @@ func (exr *updateExecutor) Run() (result driver.Result, err error) {
 	}
 
 	if resp, err = exr.dbc.call(exr.ctx, req); err != nil {
-		log.Error(exr.ctx, "db.update", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
+		exr.dbc.logger().Error(exr.ctx, "db.update", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
 		return nil, driver.ErrBadConn
 	}
 
@@ -56,19 +56,19 @@ func (exr *updateExecutor) Run() (result driver.Result, err error) {
 		sqlInfo := resp.GetSqlInfo()
 		if sqlInfo == nil {
 			sqlInfo = &MysqlInfo{
-				Sql:    astValue(exr.ast),
+				Sql:    exr.vars.value(exr.ast),
 				Vsid:   utils.GetInt32(bigid.GetVSId(exr.BigId)),
 				Dbname: exr.DBName,
 			}
 		}
 		err = NewDBError(resp.GetError(), resp.GetErrMsg(), sqlInfo)
-		log.Error(exr.ctx, "db.update", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
+		exr.dbc.logger().Error(exr.ctx, "db.update", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", err)
 		return
 	}
 
 	updateResp := resp.GetUpdateResp()
 	if updateResp == nil {
-		log.Error(exr.ctx, "db.update", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", "no update response")
+		exr.dbc.logger().Error(exr.ctx, "db.update", "logid", req.Logid, "vsid", bigid.GetVSId(exr.BigId), "conn_id", exr.dbc.id, "server_addr", exr.dbc.Addr, "error", "no update response")
 		return nil, driver.ErrBadConn
 	}
 
@@ -81,27 +81,27 @@ func (exr *updateExecutor) Run() (result driver.Result, err error) {
 
 func (exr *updateExecutor) parse() error {
 	if exr.ast.Where == nil {
-		panic(ErrSqlInvalid("must have `where` conditions"))
+		return newSQLValidationError("where", "must have `where` conditions")
 	}
 
 	if exr.ast.Where.Type != "where" {
-		panic(ErrSqlInvalid(fmt.Sprintf("filters `%v` not supported", exr.ast.Where.Type)))
+		return newSQLValidationError("where", fmt.Sprintf("filters `%v` not supported", exr.ast.Where.Type))
 	}
 
 	if exr.ast.Limit != nil {
-		panic(ErrSqlInvalid("`limit` not supported"))
+		return newSQLValidationError("limit", "`limit` not supported")
 	}
 
-	if len(astValue(exr.ast.OrderBy)) > 0 {
-		panic(ErrSqlInvalid("`order by` not supported"))
+	if len(exr.vars.value(exr.ast.OrderBy)) > 0 {
+		return newSQLValidationError("order by", "`order by` not supported")
 	}
 
 	if exr.DBName == "" {
 		exr.DBName = exr.dbc.DBName
 	}
 
-	exr.table = astValue(exr.ast.Table)
-	exr.values = astValue(exr.ast.Exprs)
-	exr.filters = astValue(exr.ast.Where.Expr)
+	exr.table = exr.vars.value(exr.ast.Table)
+	exr.values = exr.vars.value(exr.ast.Exprs)
+	exr.filters = exr.vars.value(exr.ast.Where.Expr)
 	return nil
 }
@@ -0,0 +1,75 @@
+package cdbpool
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrNullValue = errors.New("NULL values are not supported")
+)
+
+// EncodeValue formats v as the string sent to the server in KVPair,
+// CondPair and ValueList messages, along with its ValueType.
+// driver.Valuer implementations are resolved first, times are formatted
+// in UTC.
+func EncodeValue(v interface{}) (string, ValueType, error) {
+	return encodeValue(v, time.UTC)
+}
+
+// encodeValue is EncodeValue with times formatted in loc, which is the
+// location of the connection when sent on one.
+func encodeValue(v interface{}, loc *time.Location) (string, ValueType, error) {
+	if valuer, ok := v.(driver.Valuer); ok {
+		dv, err := valuer.Value()
+		if err != nil {
+			return "", ValueType_VALUE_TYPE_INVALID, err
+		}
+		v = dv
+	}
+
+	switch x := v.(type) {
+	case nil:
+		return "", ValueType_VALUE_TYPE_INVALID, ErrNullValue
+	case int:
+		return strconv.FormatInt(int64(x), 10), ValueType_VALUE_TYPE_INTEGER, nil
+	case int8:
+		return strconv.FormatInt(int64(x), 10), ValueType_VALUE_TYPE_INTEGER, nil
+	case int16:
+		return strconv.FormatInt(int64(x), 10), ValueType_VALUE_TYPE_INTEGER, nil
+	case int32:
+		return strconv.FormatInt(int64(x), 10), ValueType_VALUE_TYPE_INTEGER, nil
+	case int64:
+		return strconv.FormatInt(x, 10), ValueType_VALUE_TYPE_INTEGER, nil
+	case uint:
+		return strconv.FormatUint(uint64(x), 10), ValueType_VALUE_TYPE_INTEGER, nil
+	case uint8:
+		return strconv.FormatUint(uint64(x), 10), ValueType_VALUE_TYPE_INTEGER, nil
+	case uint16:
+		return strconv.FormatUint(uint64(x), 10), ValueType_VALUE_TYPE_INTEGER, nil
+	case uint32:
+		return strconv.FormatUint(uint64(x), 10), ValueType_VALUE_TYPE_INTEGER, nil
+	case uint64:
+		return strconv.FormatUint(x, 10), ValueType_VALUE_TYPE_INTEGER, nil
+	case float32:
+		return strconv.FormatFloat(float64(x), 'g', -1, 32), ValueType_VALUE_TYPE_FLOAT, nil
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64), ValueType_VALUE_TYPE_FLOAT, nil
+	case bool:
+		if x {
+			return "1", ValueType_VALUE_TYPE_INTEGER, nil
+		}
+		return "0", ValueType_VALUE_TYPE_INTEGER, nil
+	case string:
+		return x, ValueType_VALUE_TYPE_STRING, nil
+	case []byte:
+		return string(x), ValueType_VALUE_TYPE_STRING, nil
+	case time.Time:
+		return x.In(loc).Format(timeFormat), ValueType_VALUE_TYPE_TIME, nil
+	default:
+		return "", ValueType_VALUE_TYPE_INVALID, fmt.Errorf("unsupported type %T", v)
+	}
+}